@@ -2,13 +2,26 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/ONSdigital/dp-file-downloader/cache"
+	"github.com/ONSdigital/dp-file-downloader/compress"
 	"github.com/ONSdigital/dp-file-downloader/config"
+	"github.com/ONSdigital/dp-file-downloader/event"
+	"github.com/ONSdigital/dp-file-downloader/limit"
+	"github.com/ONSdigital/dp-file-downloader/ratelimit"
+	"github.com/ONSdigital/dp-file-downloader/warm"
 	"github.com/ONSdigital/dp-healthcheck/healthcheck"
 	dphttp "github.com/ONSdigital/dp-net/v3/http"
+	"github.com/ONSdigital/dp-net/v3/request"
 	dpotelgo "github.com/ONSdigital/dp-otel-go"
 	"github.com/ONSdigital/log.go/v2/log"
 	"github.com/gorilla/mux"
@@ -16,60 +29,165 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
-var httpServer *dphttp.Server
-
 // DownloaderAPI manages requests to download files, calling the necessary backend services to fulfill the request
 type DownloaderAPI struct {
+	server *dphttp.Server
 	router *mux.Router
-}
+	// pathPrefix is the path the downloader types are routed beneath
+	pathPrefix string
+	// handler serves the router, instrumented if OpenTelemetry is enabled
+	handler    http.Handler
+	otelConfig *dpotelgo.Config
+	stopWarmer context.CancelFunc
 
-// cannot use "go:generate moq -out testdata/mock_downloader.go -pkg testdata . Downloader" here
-// as moq can't handle build tags and incorrectly believes there are duplicate methods in gorilla/mux
-// https://github.com/matryer/moq/issues/47
-
-// Downloader defines the functions that are assigned to handle get requests
-type Downloader interface {
-	// Download retrieves/creates the file requested in the http.Request , returning:
-	// body - a reader with the contents of the file. This must be closed by the caller.
-	// headers - should include Content-Type and Content-Disposition
-	// status - the http status code - should be 200 unless there was an error
-	// err - any error that occurred during processing
-	Download(r *http.Request) (body io.ReadCloser, headers map[string]string, status int, err error)
-	// Type returns the (conceptual) type of file downloaded - forms part of the request path handled by this Downloader
-	Type() string
-	// QueryParameters returns the names of query parameters required by this Downloader
-	QueryParameters() []string
+	cachePolicy *cache.Policy
+	// cache stores rendered downloads of published content. Downloads are not cached if it is nil.
+	cache         cache.Cache
+	maxEntryBytes int64
+	// warmer pre-renders downloads into the cache. Downloads are not warmed if it is nil.
+	warmer *warm.Warmer
+	// adminToken is the bearer token required by the admin endpoints, which are not routed if it is empty
+	adminToken string
+	// limits bounds the number of downloads rendered at once. Downloads are not limited if it is nil.
+	limits     *limit.Limits
+	retryAfter time.Duration
+	// timeout is the deadline for rendering a download, unless overridden for the downloader type by timeoutByType.
+	// Downloads have no deadline if it is zero.
+	timeout       time.Duration
+	timeoutByType map[string]time.Duration
+	// routeTimeouts are the deadlines declared by the Routes of downloader types, which timeoutByType overrides
+	routeTimeouts map[string]time.Duration
+	// rateLimiter limits the rate of downloads requested by each client. Clients are not rate limited if it is nil.
+	rateLimiter *ratelimit.RateLimiter
+	// compressor compresses text downloads for clients that accept it. Downloads are not compressed if it is nil.
+	compressor *compress.Compressor
+	// drainDelay and drainTimeout control how in-flight downloads are completed before the server is shut down
+	drainDelay   time.Duration
+	drainTimeout time.Duration
+	unhealthy    atomic.Bool
+	draining     atomic.Bool
+	activeMu     sync.Mutex
+	active       map[*activeDownload]struct{}
 }
 
-// StartDownloaderAPI manages all the routes configured to the downloader
-func StartDownloaderAPI(ctx context.Context, cfg *config.Config, errorChan chan error, hc *healthcheck.HealthCheck, downloaders ...Downloader) *DownloaderAPI {
+// NewDownloaderAPI creates the api serving the routes of the downloaders, without starting its server.
+// If downloadCache is nil, rendered downloads are not cached. The health checks of the api and its downloaders are
+// added to hc, which must be started afterwards, as checks added once it has started are never run.
+func NewDownloaderAPI(ctx context.Context, cfg *config.Config, hc *healthcheck.HealthCheck, downloadCache cache.Cache, downloaders ...Downloader) (*DownloaderAPI, error) {
+	opts := Options{
+		CachePolicy: &cache.Policy{
+			MaxAge:                cfg.CacheMaxAge,
+			MaxAgeByType:          cfg.CacheMaxAgeByType,
+			SurrogateMaxAge:       cfg.CacheSurrogateMaxAge,
+			SurrogateMaxAgeByType: cfg.CacheSurrogateMaxAgeByType,
+		},
+		Cache:              downloadCache,
+		MaxCacheEntryBytes: cfg.CacheMaxEntryBytes,
+		Timeout:            cfg.DownloadTimeout,
+		TimeoutByType:      cfg.DownloadTimeoutByType,
+		Limits:             limit.NewLimits(cfg.LimitMaxInFlight, cfg.LimitMaxQueue, cfg.LimitQueueTimeout, cfg.LimitWarnRejected),
+		RetryAfter:         cfg.LimitRetryAfter,
+		DrainTimeout:       cfg.DrainTimeout,
+	}
+	if cfg.RateLimitEnabled {
+		rateLimiter, err := ratelimit.New(ratelimit.Config{
+			Rate:           cfg.RateLimitRate,
+			RateByType:     cfg.RateLimitRateByType,
+			Burst:          cfg.RateLimitBurst,
+			TrustedProxies: cfg.RateLimitTrustedProxies,
+			APIKeyHeader:   cfg.RateLimitAPIKeyHeader,
+			APIKeys:        cfg.RateLimitAPIKeys,
+			AllowList:      cfg.RateLimitAllowList,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit configuration: %w", err)
+		}
+		opts.RateLimiter = rateLimiter
+	}
+	if cfg.CompressionEnabled {
+		compressor, err := compress.New(compress.Config{
+			MinBytes:  cfg.CompressionMinBytes,
+			Brotli:    cfg.CompressionBrotli,
+			GzipLevel: cfg.CompressionGzipLevel,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid compression configuration: %w", err)
+		}
+		opts.Compressor = compressor
+	}
+
 	router := mux.NewRouter()
+	api := newAPI(router, opts)
+	api.adminToken = cfg.AdminAuthToken
+	api.drainDelay = cfg.DrainDelay
 
 	if cfg.OtelEnabled {
-		otelHandler := otelhttp.NewHandler(router, "/")
 		router.Use(otelmux.Middleware(cfg.OTServiceName))
-		httpServer = dphttp.NewServer(cfg.BindAddr, otelHandler)
-	} else {
-		httpServer = dphttp.NewServer(cfg.BindAddr, router)
+		api.handler = otelhttp.NewHandler(router, "/")
+		api.otelConfig = &dpotelgo.Config{
+			OtelBatchTimeout:         cfg.OTBatchTimeout,
+			OtelExporterOtlpEndpoint: cfg.OTExporterOTLPEndpoint,
+			OtelServiceName:          cfg.OTServiceName,
+		}
+	}
+
+	if err := hc.AddCheck("download limits", api.limits.Checker); err != nil {
+		return nil, fmt.Errorf("failed to add download limits health checker: %w", err)
+	}
+	if err := AddHealthChecks(hc, downloaders...); err != nil {
+		return nil, err
 	}
 
-	api := routes(ctx, router, hc, downloaders...)
+	if downloadCache != nil && len(cfg.CacheWarmFormats) > 0 {
+		api.warmer = warm.New(router, api.pathPrefix, cfg.CacheWarmType, warm.Config{
+			Formats:     cfg.CacheWarmFormats,
+			Languages:   cfg.CacheWarmLanguages,
+			Concurrency: cfg.CacheWarmConcurrency,
+			Rate:        cfg.CacheWarmRate,
+			QueueSize:   cfg.CacheWarmQueueSize,
+		})
+	}
+	routes(ctx, api, hc, downloaders...)
 
+	api.server = dphttp.NewServer(cfg.BindAddr, api.handler)
 	// Disable this here to allow main to manage graceful shutdown of the entire app.
-	httpServer.HandleOSSignals = false
+	api.server.HandleOSSignals = false
+	// Large files take longer to write than the default allows
+	api.server.WriteTimeout = cfg.HTTPWriteTimeout
+
+	return api, nil
+}
+
+// StartDownloaderAPI creates the api serving the routes of the downloaders and starts its server.
+// If downloadCache is nil, rendered downloads are not cached. As with NewDownloaderAPI, hc must be started afterwards.
+func StartDownloaderAPI(ctx context.Context, cfg *config.Config, errorChan chan error, hc *healthcheck.HealthCheck, downloadCache cache.Cache, downloaders ...Downloader) (*DownloaderAPI, error) {
+	api, err := NewDownloaderAPI(ctx, cfg, hc, downloadCache, downloaders...)
+	if err != nil {
+		return nil, err
+	}
+	api.Start(ctx, errorChan)
+	return api, nil
+}
+
+// Handler returns the handler serving every route of the api, e.g. to test it with httptest or mount it in another router
+func (api *DownloaderAPI) Handler() http.Handler {
+	return api.handler
+}
+
+// Start starts the server and the cache warmer in the background. Errors that stop the server are sent to errorChan.
+func (api *DownloaderAPI) Start(ctx context.Context, errorChan chan error) {
+	if api.warmer != nil {
+		warmCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		api.stopWarmer = cancel
+		api.warmer.Start(warmCtx)
+	}
 
 	go func() {
 		log.Info(ctx, "starting file downloader...")
 
-		if cfg.OtelEnabled {
+		if api.otelConfig != nil {
 			// Set up OpenTelemetry
-			otelConfig := dpotelgo.Config{
-				OtelBatchTimeout:         cfg.OTBatchTimeout,
-				OtelExporterOtlpEndpoint: cfg.OTExporterOTLPEndpoint,
-				OtelServiceName:          cfg.OTServiceName,
-			}
-
-			otelShutdown, oErr := dpotelgo.SetupOTelSDK(ctx, otelConfig)
+			otelShutdown, oErr := dpotelgo.SetupOTelSDK(ctx, *api.otelConfig)
 			if oErr != nil {
 				log.Fatal(ctx, "error setting up OpenTelemetry - hint: ensure OTEL_EXPORTER_OTLP_ENDPOINT is set", oErr)
 			}
@@ -80,33 +198,56 @@ func StartDownloaderAPI(ctx context.Context, cfg *config.Config, errorChan chan
 			}()
 		}
 
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := api.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Error(ctx, "error occurred when running ListenAndServe", err)
 			errorChan <- err
 		}
 	}()
-
-	return api
 }
 
-// routes contain all endpoints for the downloader
-func routes(ctx context.Context, router *mux.Router, hc *healthcheck.HealthCheck, downloaders ...Downloader) *DownloaderAPI {
-	api := DownloaderAPI{router: router}
+// routes contain all endpoints for the downloader. If the api has no cache policy, no caching headers are set.
+func routes(ctx context.Context, api *DownloaderAPI, hc *healthcheck.HealthCheck, downloaders ...Downloader) *DownloaderAPI {
+	api.router.StrictSlash(true).Path("/health").HandlerFunc(api.handleHealth(hc))
 
-	api.router.StrictSlash(true).Path("/health").HandlerFunc(hc.Handler)
+	api.mountDownloads(ctx, downloaders...)
 
-	for _, d := range downloaders {
-		path := "/download/" + d.Type()
-		api.router.Path(path).Methods("GET").HandlerFunc(handleDownload(d.Download))
-		log.Info(ctx, "handling GET method on path "+path, log.Data{"query_parameters": d.QueryParameters()})
+	if api.adminToken != "" {
+		api.router.Path("/debug/vars").Methods("GET").HandlerFunc(api.authorised(api.handleVars))
+		log.Info(ctx, "handling GET method on path /debug/vars")
+	}
+	if api.adminToken != "" && api.cache != nil {
+		api.router.Path("/cache/invalidate").Methods("POST").HandlerFunc(api.authorised(api.handleInvalidate))
+		log.Info(ctx, "handling POST method on path /cache/invalidate")
+	}
+	if api.adminToken != "" && api.warmer != nil {
+		api.router.Path("/cache/warm").Methods("POST").HandlerFunc(api.authorised(api.handleWarm))
+		log.Info(ctx, "handling POST method on path /cache/warm")
 	}
 
-	return &api
+	return api
 }
 
-// Close represents the graceful shutting down of the http server
-func Close(ctx context.Context) error {
-	if err := httpServer.Shutdown(ctx); err != nil {
+// PublishHandlers returns the event handlers that keep the download cache up to date when content is published:
+// cached downloads of the published uris are invalidated, then warmed again. They are a hook for services that
+// receive publishing events; this service doesn't consume any itself.
+func (api *DownloaderAPI) PublishHandlers() []event.Handler {
+	var handlers []event.Handler
+	if api.cache != nil {
+		handlers = append(handlers, cache.InvalidateOnPublish(api.cache))
+	}
+	if api.warmer != nil {
+		handlers = append(handlers, api.warmer.OnPublish())
+	}
+	return handlers
+}
+
+// Shutdown represents the graceful shutting down of the http server. Drain should be called first, so that
+// in-flight downloads aren't cut off.
+func (api *DownloaderAPI) Shutdown(ctx context.Context) error {
+	if api.stopWarmer != nil {
+		api.stopWarmer()
+	}
+	if err := api.server.Shutdown(ctx); err != nil {
 		return err
 	}
 
@@ -114,37 +255,224 @@ func Close(ctx context.Context) error {
 	return nil
 }
 
-// handleDownload accepts a Downloader.Download function and wraps it in a handler that writes the content to an http.ResponseWriter.
-func handleDownload(handler func(r *http.Request) (io.ReadCloser, map[string]string, int, error)) func(http.ResponseWriter, *http.Request) {
+// handleDownload accepts a Downloader and wraps its Download function in a handler that writes the content to an http.ResponseWriter.
+func (api *DownloaderAPI) handleDownload(d Downloader) func(http.ResponseWriter, *http.Request) {
+	route := routeOf(d)
 	return func(w http.ResponseWriter, request *http.Request) {
-		reader, headers, status, err := handler(request)
-		ctx := request.Context()
-		defer func() {
-			if reader != nil {
-				readerErr := reader.Close()
-				if readerErr != nil {
-					log.Error(ctx, "unable to close reader cleanly", readerErr)
-				}
-			}
-		}()
+		if api.draining.Load() {
+			api.rejectDraining(w, request, d.Type())
+			return
+		}
+		ctx, done := api.track(request, d.Type())
+		defer done()
+		request = hostLanguage(request.WithContext(ctx))
+
+		req, err := newRequest(request, d, route)
 		if err != nil {
-			log.Error(ctx, "handleDownload: Error returned from handler", err, log.Data{"request:": request})
-			if status < 400 {
-				status = http.StatusInternalServerError
-			}
+			log.Warn(ctx, "handleDownload: Invalid request", log.Data{"url": request.URL.String(), "error": err.Error()})
+			status := statusOf(ctx, err)
+			api.setCacheHeaders(w, request, d.Type(), status)
 			http.Error(w, err.Error(), status)
-		} else {
-			for key, value := range headers {
-				w.Header().Add(key, value)
+			return
+		}
+		// requests on the paths of the route are handled as the equivalent request with a query
+		resolved := *request.URL
+		resolved.RawQuery = req.Params.Encode()
+		request.URL = &resolved
+		link := api.canonicalLink(d, route, req.Params)
+
+		entryKey, cacheable := api.cacheKey(request, req.Params, d.Type())
+		if cacheable {
+			if entry, ok := api.cache.Get(ctx, entryKey); ok {
+				if link != "" {
+					w.Header().Add("Link", link)
+				}
+				api.writeCachedDownload(w, request, d.Type(), entry)
+				return
 			}
-			w.WriteHeader(status)
-			// write body
-			_, err := io.Copy(w, reader)
+		}
+
+		ctx, stopDeadline, cancel := withRenderDeadline(ctx, api.downloadTimeout(d.Type()))
+		defer cancel()
+		request = request.WithContext(ctx)
+
+		if api.limits != nil {
+			release, err := api.limits.Acquire(ctx, d.Type(), req.Params.Get("format"))
 			if err != nil {
-				log.Error(ctx, "handleDownload: Error while copying from reader", err, log.Data{"request:": request})
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				api.reject(w, request, d.Type(), err)
 				return
 			}
+			defer release()
 		}
+
+		result, err := d.Download(ctx, req)
+		if err != nil {
+			log.Error(ctx, "handleDownload: Error returned from handler", err, log.Data{"request:": request})
+			status := statusOf(ctx, err)
+			api.setCacheHeaders(w, request, d.Type(), status)
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if result.Body == nil {
+			result.Body = http.NoBody
+		}
+		defer func() {
+			if readerErr := result.Body.Close(); readerErr != nil {
+				log.Error(ctx, "unable to close reader cleanly", readerErr)
+			}
+		}()
+
+		headers := result.header()
+		for key, values := range headers {
+			w.Header()[key] = append(w.Header()[key], values...)
+		}
+		status := result.status()
+		if link != "" && status == http.StatusOK {
+			w.Header().Add("Link", link)
+		}
+		// downloads that set their own caching headers, e.g. previews, are never stored
+		cacheable = cacheable && status == http.StatusOK && w.Header().Get("Cache-Control") == ""
+		api.setCacheHeaders(w, request, d.Type(), status)
+		if request.Method == http.MethodHead || isBufferable(result) {
+			api.writeBuffered(w, request, entryKey, cacheable, status, headers, result.Body, stopDeadline)
+			return
+		}
+		stopDeadline()
+		api.writeStreamed(w, request, entryKey, cacheable, status, headers, result.Body)
+	}
+}
+
+// writeBuffered reads the whole download before writing it, so that its length and digest are sent as headers.
+// Only the headers are written in response to a HEAD request, and cacheable downloads are stored so that a following
+// GET is served without rendering the download again. stopDeadline is called once the download has been read.
+func (api *DownloaderAPI) writeBuffered(w http.ResponseWriter, r *http.Request, key cache.Key, cacheable bool, status int, headers http.Header, reader io.Reader, stopDeadline func()) {
+	ctx := r.Context()
+
+	// a HEAD request only needs the body if it is to be cached
+	body := &cappedBuffer{limit: maxBufferedBytes}
+	if r.Method == http.MethodHead {
+		body.limit = 0
+		if cacheable {
+			body.limit = api.maxEntryBytes
+		}
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(hash, body), reader)
+	stopDeadline()
+	if err == nil && r.Method != http.MethodHead && body.overflowed {
+		err = fmt.Errorf("download is larger than its Content-Length of %s", headers.Get("Content-Length"))
+	}
+	if err != nil {
+		log.Error(ctx, "writeBuffered: Error while reading download", err, log.Data{"request:": r})
+		w.Header().Del("Content-Disposition")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sum := hash.Sum(nil)
+
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	setDigestHeaders(w.Header(), sum)
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		if _, err := w.Write(body.Bytes()); err != nil {
+			log.Error(ctx, "writeBuffered: Error while writing download", err, log.Data{"request:": r})
+			return
+		}
+	}
+
+	if cacheable && !body.overflowed && size <= api.maxEntryBytes {
+		api.storeDownload(ctx, key, headers, body.Bytes(), sum)
+	}
+}
+
+// writeStreamed copies the download to the response as it is read. If its length is unknown, the response is chunked
+// and its digest is sent as a trailer.
+func (api *DownloaderAPI) writeStreamed(w http.ResponseWriter, r *http.Request, key cache.Key, cacheable bool, status int, headers http.Header, reader io.Reader) {
+	ctx := r.Context()
+
+	// trailers can only be sent with a chunked response
+	trailers := w.Header().Get("Content-Length") == ""
+	if trailers {
+		w.Header().Set("Trailer", reprDigestHeader+", "+digestHeader)
+	}
+	w.WriteHeader(status)
+
+	hash := sha256.New()
+	dst := io.MultiWriter(w, hash)
+	var captured *cappedBuffer
+	if cacheable {
+		captured = &cappedBuffer{limit: api.maxEntryBytes}
+		dst = io.MultiWriter(w, hash, captured)
+	}
+	if _, err := io.Copy(dst, reader); err != nil {
+		// the status has already been sent, so the client can only be told the download is incomplete by aborting it
+		log.Error(ctx, "handleDownload: Error while copying from reader", err, log.Data{"request:": r})
+		panic(http.ErrAbortHandler)
+	}
+	sum := hash.Sum(nil)
+	if trailers {
+		setDigestHeaders(w.Header(), sum)
+	}
+	if captured != nil && !captured.overflowed {
+		api.storeDownload(ctx, key, headers, captured.Bytes(), sum)
+	}
+}
+
+// downloadTimeout returns the deadline for rendering a download of the downloader type: that configured for the
+// type, or declared by its Route, or else the default
+func (api *DownloaderAPI) downloadTimeout(downloaderType string) time.Duration {
+	if timeout, ok := api.timeoutByType[downloaderType]; ok {
+		return timeout
+	}
+	if timeout, ok := api.routeTimeouts[downloaderType]; ok {
+		return timeout
+	}
+	return api.timeout
+}
+
+// reject responds to a download that can't be processed because too many downloads are in progress
+func (api *DownloaderAPI) reject(w http.ResponseWriter, r *http.Request, downloaderType string, err error) {
+	ctx := r.Context()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Warn(ctx, "handleDownload: Download timed out while queued", log.Data{"type": downloaderType})
+		api.setCacheHeaders(w, r, downloaderType, http.StatusGatewayTimeout)
+		http.Error(w, "timed out waiting for other downloads to complete", http.StatusGatewayTimeout)
+		return
+	}
+	if ctx.Err() != nil {
+		// the client has gone away while the download was queued
+		return
+	}
+	log.Warn(ctx, "handleDownload: Rejecting download", log.Data{"type": downloaderType, "error": err.Error()})
+	w.Header().Set("Retry-After", strconv.Itoa(int(api.retryAfter.Seconds())))
+	api.setCacheHeaders(w, r, downloaderType, http.StatusServiceUnavailable)
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+}
+
+// setCacheHeaders applies the cache policy to the response, unless the Downloader has already set a Cache-Control header.
+// Requests from Florence users, or for content in a collection, are never cached by shared caches.
+func (api *DownloaderAPI) setCacheHeaders(w http.ResponseWriter, r *http.Request, downloaderType string, status int) {
+	if api.cachePolicy == nil || w.Header().Get("Cache-Control") != "" {
+		return
+	}
+
+	headers := api.cachePolicy.ErrorHeaders()
+	if status < http.StatusMultipleChoices {
+		headers = api.cachePolicy.Headers(downloaderType, r.URL.Query().Get("uri"), isPrivate(r))
+	}
+	for key, value := range headers {
+		w.Header().Set(key, value)
+	}
+}
+
+// isPrivate returns true if the request is for unpublished content or made by an authenticated Florence user
+func isPrivate(r *http.Request) bool {
+	if collectionID, _ := request.GetCollectionID(r); collectionID != "" {
+		return true
+	}
+	if r.Header.Get(request.FlorenceHeaderKey) != "" {
+		return true
 	}
+	_, err := r.Cookie(request.FlorenceCookieKey)
+	return err == nil
 }
@@ -4,13 +4,28 @@ import (
 	"context"
 	"testing"
 
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
+	"testing/iotest"
+	"time"
 
 	"github.com/ONSdigital/dp-file-downloader/api/testdata"
+	"github.com/ONSdigital/dp-file-downloader/cache"
+	"github.com/ONSdigital/dp-file-downloader/compress"
+	"github.com/ONSdigital/dp-file-downloader/config"
+	"github.com/ONSdigital/dp-file-downloader/limit"
+	"github.com/ONSdigital/dp-file-downloader/ratelimit"
+	"github.com/ONSdigital/dp-file-downloader/warm"
 	"github.com/ONSdigital/dp-healthcheck/healthcheck"
 	"github.com/gorilla/mux"
 	. "github.com/smartystreets/goconvey/convey"
@@ -33,7 +48,7 @@ func TestSuccessfulDownload(t *testing.T) {
 	Convey("Given an api with a mock implementation of Downloader", t, func() {
 		mockDownloader := createMockDownloader("mock", []string{queryParam}, responseBody, http.StatusOK, nil)
 
-		api := routes(ctx, mux.NewRouter(), &hcMock, mockDownloader)
+		api := routes(ctx, &DownloaderAPI{router: mux.NewRouter(), pathPrefix: defaultPathPrefix}, &hcMock, Adapt(mockDownloader))
 
 		Convey("When a route is invoked ", func() {
 			url := baseURL + mockDownloader.Type() + "?" + queryParam + "=" + queryValue
@@ -64,7 +79,7 @@ func TestReturnsCorrectStatus(t *testing.T) {
 	Convey("Given an api with a mock implementation of Downloader", t, func() {
 		mockDownloader := createMockDownloader("mock", []string{queryParam}, responseBody, http.StatusBadRequest, nil)
 
-		api := routes(ctx, mux.NewRouter(), &hcMock, mockDownloader)
+		api := routes(ctx, &DownloaderAPI{router: mux.NewRouter(), pathPrefix: defaultPathPrefix}, &hcMock, Adapt(mockDownloader))
 
 		Convey("When a route is invoked ", func() {
 			url := baseURL + mockDownloader.Type() + "?" + queryParam + "=" + queryValue
@@ -90,7 +105,7 @@ func TestNotFound(t *testing.T) {
 	Convey("Given an api with a mock implementation of Downloader", t, func() {
 		mockDownloader := createMockDownloader("mock", []string{queryParam}, responseBody, http.StatusOK, nil)
 
-		api := routes(ctx, mux.NewRouter(), &hcMock, mockDownloader)
+		api := routes(ctx, &DownloaderAPI{router: mux.NewRouter(), pathPrefix: defaultPathPrefix}, &hcMock, Adapt(mockDownloader))
 
 		Convey("When a route is invoked with the wrong type", func() {
 			r, err := http.NewRequest("GET", "http://localhost/download/foo"+"?"+queryParam+"="+queryValue, http.NoBody)
@@ -113,7 +128,7 @@ func TestReturnsError(t *testing.T) {
 		downloadError := errors.New("This is an error")
 		mockDownloader := createMockDownloader("mock", []string{queryParam}, responseBody, http.StatusOK, downloadError)
 
-		api := routes(ctx, mux.NewRouter(), &hcMock, mockDownloader)
+		api := routes(ctx, &DownloaderAPI{router: mux.NewRouter(), pathPrefix: defaultPathPrefix}, &hcMock, Adapt(mockDownloader))
 
 		Convey("When a route is invoked ", func() {
 			url := baseURL + mockDownloader.Type() + "?" + queryParam + "=" + queryValue
@@ -137,7 +152,7 @@ func TestReturnsBadRequest(t *testing.T) {
 		downloadError := errors.New("That was a bad request")
 		mockDownloader := createMockDownloader("mock", []string{queryParam}, responseBody, http.StatusBadRequest, downloadError)
 
-		api := routes(ctx, mux.NewRouter(), &hcMock, mockDownloader)
+		api := routes(ctx, &DownloaderAPI{router: mux.NewRouter(), pathPrefix: defaultPathPrefix}, &hcMock, Adapt(mockDownloader))
 
 		Convey("When a route is invoked ", func() {
 			url := baseURL + mockDownloader.Type() + "?" + queryParam + "=" + queryValue
@@ -155,6 +170,1281 @@ func TestReturnsBadRequest(t *testing.T) {
 	})
 }
 
+func TestCacheHeaders(t *testing.T) {
+	t.Parallel()
+	Convey("Given an api with a cache policy and a mock implementation of Downloader", t, func() {
+		policy := &cache.Policy{MaxAge: time.Minute, SurrogateMaxAge: time.Hour}
+		mockDownloader := createMockDownloader("mock", []string{uriParam}, responseBody, http.StatusOK, nil)
+
+		api := routes(ctx, &DownloaderAPI{router: mux.NewRouter(), pathPrefix: defaultPathPrefix, cachePolicy: policy}, &hcMock, Adapt(mockDownloader))
+
+		Convey("When a published file is requested", func() {
+			r, err := http.NewRequest("GET", baseURL+mockDownloader.Type()+"?"+uriParam+"=/foo/bar.json", http.NoBody)
+			So(err, ShouldBeNil)
+
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+
+			Convey("The response should be cacheable", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Cache-Control"), ShouldEqual, "public, max-age=60")
+				So(w.Header().Get("Surrogate-Control"), ShouldEqual, "max-age=3600")
+				So(w.Header().Get("Surrogate-Key"), ShouldEqual, "mock mock:/foo/bar")
+				So(w.Header().Get("Vary"), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a file is requested from a collection", func() {
+			r, err := http.NewRequest("GET", baseURL+mockDownloader.Type()+"?"+uriParam+"=/foo/bar.json", http.NoBody)
+			So(err, ShouldBeNil)
+			r.Header.Set("Collection-Id", "my-collection")
+
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+
+			Convey("The response should be private", func() {
+				So(w.Header().Get("Cache-Control"), ShouldEqual, "private, no-store")
+				So(w.Header().Get("Surrogate-Key"), ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given an api with a cache policy and a Downloader that returns an error", t, func() {
+		policy := &cache.Policy{MaxAge: time.Minute, SurrogateMaxAge: time.Hour}
+		mockDownloader := createMockDownloader("mock", []string{uriParam}, responseBody, http.StatusNotFound, errors.New("not found"))
+
+		api := routes(ctx, &DownloaderAPI{router: mux.NewRouter(), pathPrefix: defaultPathPrefix, cachePolicy: policy}, &hcMock, Adapt(mockDownloader))
+
+		Convey("When a route is invoked", func() {
+			r, err := http.NewRequest("GET", baseURL+mockDownloader.Type()+"?"+uriParam+"=/foo/bar.json", http.NoBody)
+			So(err, ShouldBeNil)
+
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+
+			Convey("The error response should not be cached", func() {
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+				So(w.Header().Get("Cache-Control"), ShouldEqual, "no-store")
+			})
+		})
+	})
+}
+
+func TestDownloadCache(t *testing.T) {
+	t.Parallel()
+	Convey("Given an api with a download cache", t, func() {
+		mockDownloader := createMockDownloader("mock", []string{uriParam}, responseBody, http.StatusOK, nil)
+		api := routes(ctx, &DownloaderAPI{
+			router:        mux.NewRouter(),
+			pathPrefix:    defaultPathPrefix,
+			cachePolicy:   &cache.Policy{MaxAge: time.Minute},
+			cache:         cache.NewMemory(1024, time.Hour),
+			maxEntryBytes: 1024,
+			adminToken:    "secret",
+		}, &hcMock, Adapt(mockDownloader))
+
+		get := func(query string, header map[string]string) *httptest.ResponseRecorder {
+			r, err := http.NewRequest("GET", baseURL+mockDownloader.Type()+"?"+query, http.NoBody)
+			So(err, ShouldBeNil)
+			for key, value := range header {
+				r.Header.Set(key, value)
+			}
+			if host := r.Header.Get("Host"); host != "" {
+				r.Host = host
+			}
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+			return w
+		}
+
+		invalidate := func(body, token string) *httptest.ResponseRecorder {
+			r, err := http.NewRequest("POST", "http://localhost:80/cache/invalidate", strings.NewReader(body))
+			So(err, ShouldBeNil)
+			if token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("When the same published file is requested twice", func() {
+			get(uriParam+"=/foo/bar.json&format=csv", nil)
+			w := get("format=csv&"+uriParam+"=/foo/bar", nil)
+
+			Convey("The second response should be served from the cache", func() {
+				So(len(mockDownloader.DownloadCalls()), ShouldEqual, 1)
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldEqual, responseBody)
+				So(w.Header().Get("Content-Type"), ShouldEqual, "text/plain")
+				So(w.Header().Get("Cache-Control"), ShouldEqual, "public, max-age=60")
+			})
+		})
+
+		Convey("When a cached file is requested with its ETag or a range", func() {
+			mockDownloader.DownloadFunc = func(r *http.Request) (io.ReadCloser, map[string]string, int, error) {
+				headers := map[string]string{"Content-Type": "text/plain", "ETag": `"v1"`}
+				return io.NopCloser(strings.NewReader(responseBody)), headers, http.StatusOK, nil
+			}
+			get(uriParam+"=/foo/bar", nil)
+			notModified := get(uriParam+"=/foo/bar", map[string]string{"If-None-Match": `"v1"`})
+			partial := get(uriParam+"=/foo/bar", map[string]string{"Range": "bytes=0-3"})
+
+			Convey("They should be answered from the cache", func() {
+				So(len(mockDownloader.DownloadCalls()), ShouldEqual, 1)
+				So(notModified.Code, ShouldEqual, http.StatusNotModified)
+				So(notModified.Header().Get("ETag"), ShouldEqual, `"v1"`)
+				So(notModified.Body.Len(), ShouldEqual, 0)
+				So(partial.Code, ShouldEqual, http.StatusPartialContent)
+				So(partial.Header().Get("Accept-Ranges"), ShouldEqual, "bytes")
+				So(partial.Header().Get("Content-Range"), ShouldEqual, "bytes 0-3/"+strconv.Itoa(len(responseBody)))
+				So(partial.Body.String(), ShouldEqual, responseBody[:4])
+			})
+		})
+
+		Convey("When a published file is requested with a lang cookie", func() {
+			get(uriParam+"=/foo/bar&format=csv", nil)
+			w := get(uriParam+"=/foo/bar&format=csv", map[string]string{"Cookie": "lang=cy"})
+
+			Convey("It should be served in the language of its host, from the cache, as shared caches don't key on the cookie", func() {
+				So(len(mockDownloader.DownloadCalls()), ShouldEqual, 1)
+				So(w.Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When different formats and languages of a file are requested", func() {
+			get(uriParam+"=/foo/bar&format=csv", nil)
+			get(uriParam+"=/foo/bar&format=xlsx", nil)
+			get(uriParam+"=/foo/bar&format=csv", map[string]string{"Host": "cy.localhost"})
+
+			Convey("Each should be downloaded", func() {
+				So(len(mockDownloader.DownloadCalls()), ShouldEqual, 3)
+			})
+
+			Convey("And the uri is invalidated", func() {
+				w := invalidate(`{"uris":["/foo/bar.json"]}`, "secret")
+
+				Convey("Every variant should be removed from the cache", func() {
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(w.Body.String(), ShouldEqual, `{"invalidated":3}`+"\n")
+					get(uriParam+"=/foo/bar&format=csv", nil)
+					So(len(mockDownloader.DownloadCalls()), ShouldEqual, 4)
+				})
+			})
+
+			Convey("And a prefix is invalidated", func() {
+				w := invalidate(`{"prefixes":["/foo/"]}`, "secret")
+
+				Convey("Every uri under it should be removed from the cache", func() {
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(w.Body.String(), ShouldEqual, `{"invalidated":3}`+"\n")
+				})
+			})
+		})
+
+		Convey("When a file is requested from a collection", func() {
+			get(uriParam+"=/foo/bar", map[string]string{"Collection-Id": "my-collection"})
+			get(uriParam+"=/foo/bar", map[string]string{"Collection-Id": "my-collection"})
+
+			Convey("It should not be cached", func() {
+				So(len(mockDownloader.DownloadCalls()), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When an invalidation is requested without the admin token", func() {
+			w := invalidate(`{"uris":["/foo/bar"]}`, "wrong")
+
+			Convey("Then a 401 response should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			})
+		})
+
+		Convey("When an invalidation is requested with no uris", func() {
+			w := invalidate(`{}`, "secret")
+
+			Convey("Then a 400 response should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+	})
+}
+
+func TestWarmCache(t *testing.T) {
+	t.Parallel()
+	Convey("Given an api with a download cache and a started warmer", t, func() {
+		warmCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		mockDownloader := createMockDownloader("mock", []string{uriParam}, responseBody, http.StatusOK, nil)
+		router := mux.NewRouter()
+		api := &DownloaderAPI{
+			router:        router,
+			pathPrefix:    defaultPathPrefix,
+			cache:         cache.NewMemory(1024, time.Hour),
+			maxEntryBytes: 1024,
+			warmer:        warm.New(router, defaultPathPrefix, "mock", warm.Config{Formats: []string{"csv", "xlsx"}, Languages: []string{"en"}, QueueSize: 10}),
+			adminToken:    "secret",
+		}
+		routes(ctx, api, &hcMock, Adapt(mockDownloader))
+		api.warmer.Start(warmCtx)
+
+		Convey("When a uri is warmed", func() {
+			r, err := http.NewRequest("POST", "http://localhost:80/cache/warm", strings.NewReader(`{"uris":["/foo/bar"]}`))
+			So(err, ShouldBeNil)
+			r.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+
+			Convey("Every configured format should be queued", func() {
+				So(w.Code, ShouldEqual, http.StatusAccepted)
+				So(w.Body.String(), ShouldEqual, `{"queued":2}`+"\n")
+			})
+
+			Convey("The downloads should then be served from the cache", func() {
+				So(waitFor(func() bool { return len(mockDownloader.DownloadCalls()) == 2 }), ShouldBeTrue)
+				So(waitFor(func() bool {
+					_, ok := api.cache.Get(ctx, cache.NewKey("mock", "/foo/bar", "format=xlsx|en"))
+					return ok
+				}), ShouldBeTrue)
+
+				r, err := http.NewRequest("GET", baseURL+"mock?"+uriParam+"=/foo/bar&format=csv", http.NoBody)
+				So(err, ShouldBeNil)
+				w := httptest.NewRecorder()
+				api.router.ServeHTTP(w, r)
+				So(w.Body.String(), ShouldEqual, responseBody)
+				So(len(mockDownloader.DownloadCalls()), ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestConcurrencyLimits(t *testing.T) {
+	t.Parallel()
+	Convey("Given an api limited to one download at a time, with a download in progress", t, func() {
+		unblock := make(chan struct{})
+		mockDownloader := createMockDownloader("mock", []string{queryParam}, responseBody, http.StatusOK, nil)
+		mockDownloader.DownloadFunc = func(r *http.Request) (io.ReadCloser, map[string]string, int, error) {
+			<-unblock
+			return io.NopCloser(strings.NewReader(responseBody)), responseHeaders, http.StatusOK, nil
+		}
+		api := routes(ctx, &DownloaderAPI{
+			router:     mux.NewRouter(),
+			pathPrefix: defaultPathPrefix,
+			limits:     limit.NewLimits(map[string]int{"mock": 1}, 0, time.Millisecond, 1),
+			retryAfter: 30 * time.Second,
+		}, &hcMock, Adapt(mockDownloader))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			r, _ := http.NewRequest("GET", baseURL+"mock?"+queryParam+"=/a", http.NoBody)
+			api.router.ServeHTTP(httptest.NewRecorder(), r)
+		}()
+		So(waitFor(func() bool { return len(mockDownloader.DownloadCalls()) == 1 }), ShouldBeTrue)
+
+		Convey("When another download is requested", func() {
+			r, err := http.NewRequest("GET", baseURL+"mock?"+queryParam+"=/b", http.NoBody)
+			So(err, ShouldBeNil)
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+			close(unblock)
+			<-done
+
+			Convey("Then a 503 response should be returned with Retry-After", func() {
+				So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+				So(w.Header().Get("Retry-After"), ShouldEqual, "30")
+				So(len(mockDownloader.DownloadCalls()), ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+	Convey("Given an api that rate limits clients to one download at once", t, func() {
+		rateLimiter, err := ratelimit.New(ratelimit.Config{Rate: 0.001, Burst: 1})
+		So(err, ShouldBeNil)
+		mockDownloader := createMockDownloader("mock", []string{queryParam}, responseBody, http.StatusOK, nil)
+		api := routes(ctx, &DownloaderAPI{router: mux.NewRouter(), pathPrefix: defaultPathPrefix, rateLimiter: rateLimiter}, &hcMock, Adapt(mockDownloader))
+
+		Convey("When a client requests two downloads", func() {
+			var w *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				r, err := http.NewRequest("GET", baseURL+"mock?"+queryParam+"=/a", http.NoBody)
+				So(err, ShouldBeNil)
+				r.RemoteAddr = "1.2.3.4:1000"
+				w = httptest.NewRecorder()
+				api.router.ServeHTTP(w, r)
+			}
+
+			Convey("The second should be rejected with a 429", func() {
+				So(w.Code, ShouldEqual, http.StatusTooManyRequests)
+				So(len(mockDownloader.DownloadCalls()), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When a client requests a download and then its checksum", func() {
+			var w *httptest.ResponseRecorder
+			for _, path := range []string{"mock", "mock" + checksumSuffix} {
+				r, err := http.NewRequest("GET", baseURL+path+"?"+queryParam+"=/a", http.NoBody)
+				So(err, ShouldBeNil)
+				r.RemoteAddr = "1.2.3.4:1000"
+				w = httptest.NewRecorder()
+				api.router.ServeHTTP(w, r)
+			}
+
+			Convey("The checksum should be rejected with a 429, as the paths of a downloader share its limit", func() {
+				So(w.Code, ShouldEqual, http.StatusTooManyRequests)
+				So(len(mockDownloader.DownloadCalls()), ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	t.Parallel()
+	Convey("Given an api with a download deadline and a Downloader slower than it", t, func() {
+		mockDownloader := createMockDownloader("mock", []string{queryParam}, responseBody, http.StatusOK, nil)
+		mockDownloader.DownloadFunc = func(r *http.Request) (io.ReadCloser, map[string]string, int, error) {
+			<-r.Context().Done()
+			return nil, nil, http.StatusInternalServerError, r.Context().Err()
+		}
+		api := routes(ctx, &DownloaderAPI{
+			router:        mux.NewRouter(),
+			pathPrefix:    defaultPathPrefix,
+			timeout:       time.Hour,
+			timeoutByType: map[string]time.Duration{"mock": 10 * time.Millisecond},
+		}, &hcMock, Adapt(mockDownloader))
+
+		Convey("When a route is invoked", func() {
+			r, err := http.NewRequest("GET", baseURL+"mock?"+queryParam+"=/a", http.NoBody)
+			So(err, ShouldBeNil)
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+
+			Convey("Then a 504 response should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusGatewayTimeout)
+				So(strings.TrimSpace(w.Body.String()), ShouldEqual, context.DeadlineExceeded.Error())
+			})
+		})
+	})
+
+	Convey("Given an api with a download deadline and a Downloader whose body takes longer than it to write", t, func() {
+		mockDownloader := createMockDownloader("mock", []string{queryParam}, responseBody, http.StatusOK, nil)
+		mockDownloader.DownloadFunc = func(r *http.Request) (io.ReadCloser, map[string]string, int, error) {
+			body := io.MultiReader(strings.NewReader("slowly "), readerFunc(func(p []byte) (int, error) {
+				time.Sleep(50 * time.Millisecond)
+				if err := r.Context().Err(); err != nil {
+					return 0, err
+				}
+				return copy(p, "written"), io.EOF
+			}))
+			return io.NopCloser(body), nil, http.StatusOK, nil
+		}
+		api := routes(ctx, &DownloaderAPI{router: mux.NewRouter(), pathPrefix: defaultPathPrefix, timeout: 10 * time.Millisecond}, &hcMock, Adapt(mockDownloader))
+
+		Convey("When a route is invoked", func() {
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, httptest.NewRequest("GET", baseURL+"mock?"+queryParam+"=/a", http.NoBody))
+
+			Convey("Then the whole download should be written, as the deadline only bounds rendering its headers", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldEqual, "slowly written")
+			})
+		})
+	})
+}
+
+func TestStreamedDownloadFailure(t *testing.T) {
+	t.Parallel()
+	Convey("Given a Downloader whose body fails part way through being streamed", t, func() {
+		mockDownloader := createMockDownloader("mock", []string{queryParam}, responseBody, http.StatusOK, nil)
+		mockDownloader.DownloadFunc = func(r *http.Request) (io.ReadCloser, map[string]string, int, error) {
+			body := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("renderer failed")))
+			return io.NopCloser(body), nil, http.StatusOK, nil
+		}
+		api := routes(ctx, &DownloaderAPI{router: mux.NewRouter(), pathPrefix: defaultPathPrefix}, &hcMock, Adapt(mockDownloader))
+		server := httptest.NewServer(api.router)
+		defer server.Close()
+
+		Convey("When it is downloaded", func() {
+			// the connection is closed before the headers if they haven't been flushed yet, or else part way through the body
+			response, err := http.Get(server.URL + "/download/mock?" + queryParam + "=/a")
+			if err == nil {
+				defer response.Body.Close()
+				_, err = io.ReadAll(response.Body)
+			}
+
+			Convey("Then the response should be aborted, so that the client can tell it is incomplete", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+// readerFunc is an io.Reader that calls the function
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestDrain(t *testing.T) {
+	t.Parallel()
+	Convey("Given an api with a download in progress", t, func() {
+		unblock := make(chan struct{})
+		aborted := make(chan error, 1)
+		mockDownloader := createMockDownloader("mock", []string{queryParam}, responseBody, http.StatusOK, nil)
+		mockDownloader.DownloadFunc = func(r *http.Request) (io.ReadCloser, map[string]string, int, error) {
+			select {
+			case <-unblock:
+				return io.NopCloser(strings.NewReader(responseBody)), responseHeaders, http.StatusOK, nil
+			case <-r.Context().Done():
+				aborted <- r.Context().Err()
+				return nil, nil, http.StatusInternalServerError, r.Context().Err()
+			}
+		}
+		api := routes(ctx, &DownloaderAPI{router: mux.NewRouter(), pathPrefix: defaultPathPrefix, drainTimeout: time.Second}, &hcMock, Adapt(mockDownloader))
+
+		inFlight := httptest.NewRecorder()
+		completed := make(chan struct{})
+		go func() {
+			defer close(completed)
+			r, _ := http.NewRequest("GET", baseURL+"mock?"+queryParam+"=/a", http.NoBody)
+			api.router.ServeHTTP(inFlight, r)
+		}()
+		So(waitFor(func() bool { return api.activeCount() == 1 }), ShouldBeTrue)
+
+		get := func(url string) *httptest.ResponseRecorder {
+			r, err := http.NewRequest("GET", url, http.NoBody)
+			So(err, ShouldBeNil)
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("When the api is drained and the download completes within the drain timeout", func() {
+			drained := make(chan struct{})
+			go func() {
+				defer close(drained)
+				api.Drain(ctx)
+			}()
+			So(waitFor(func() bool { return api.draining.Load() }), ShouldBeTrue)
+
+			health := get("http://localhost:80/health")
+			rejected := get(baseURL + "mock?" + queryParam + "=/b")
+			close(unblock)
+			<-drained
+			<-completed
+
+			Convey("The health endpoint should report the service as unavailable", func() {
+				So(health.Code, ShouldEqual, http.StatusServiceUnavailable)
+			})
+
+			Convey("New downloads should be rejected", func() {
+				So(rejected.Code, ShouldEqual, http.StatusServiceUnavailable)
+				So(rejected.Header().Get("Connection"), ShouldEqual, "close")
+			})
+
+			Convey("The in-flight download should complete", func() {
+				So(inFlight.Code, ShouldEqual, http.StatusOK)
+				So(inFlight.Body.String(), ShouldEqual, responseBody)
+			})
+		})
+
+		Convey("When the api is drained and the download doesn't complete within the drain timeout", func() {
+			api.drainTimeout = 10 * time.Millisecond
+			api.Drain(ctx)
+			<-completed
+
+			Convey("The download should be aborted", func() {
+				So(<-aborted, ShouldEqual, context.Canceled)
+				So(api.activeCount(), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestNewDownloaderAPI(t *testing.T) {
+	t.Parallel()
+	Convey("Given two apis created from the same configuration", t, func() {
+		cfg, err := config.Get()
+		So(err, ShouldBeNil)
+		cfg.AdminAuthToken = "secret"
+
+		hc := healthcheck.New(healthcheck.VersionInfo{}, time.Minute, time.Minute)
+
+		first := createMockDownloader("mock", []string{queryParam}, "first", http.StatusOK, nil)
+		firstAPI, err := NewDownloaderAPI(ctx, cfg, &hc, nil, Adapt(first))
+		So(err, ShouldBeNil)
+		second := createMockDownloader("mock", []string{queryParam}, "second", http.StatusOK, nil)
+		secondAPI, err := NewDownloaderAPI(ctx, cfg, &hc, nil, Adapt(second))
+		So(err, ShouldBeNil)
+
+		firstServer := httptest.NewServer(firstAPI.Handler())
+		defer firstServer.Close()
+		secondServer := httptest.NewServer(secondAPI.Handler())
+		defer secondServer.Close()
+
+		Convey("When a file is downloaded from each server", func() {
+			firstResponse, err := http.Get(firstServer.URL + "/download/mock?" + queryParam + "=/a")
+			So(err, ShouldBeNil)
+			defer firstResponse.Body.Close()
+			secondResponse, err := http.Get(secondServer.URL + "/download/mock?" + queryParam + "=/a")
+			So(err, ShouldBeNil)
+			defer secondResponse.Body.Close()
+
+			Convey("Each should be served by its own downloader, with the configured cache policy", func() {
+				firstBody, err := io.ReadAll(firstResponse.Body)
+				So(err, ShouldBeNil)
+				So(string(firstBody), ShouldEqual, "first")
+				So(firstResponse.Header.Get("Cache-Control"), ShouldEqual, "public, max-age=300")
+				secondBody, err := io.ReadAll(secondResponse.Body)
+				So(err, ShouldBeNil)
+				So(string(secondBody), ShouldEqual, "second")
+			})
+		})
+
+		Convey("When the metrics are requested without the admin token", func() {
+			response, err := http.Get(firstServer.URL + "/debug/vars")
+			So(err, ShouldBeNil)
+			defer response.Body.Close()
+
+			Convey("They should not be served", func() {
+				So(response.StatusCode, ShouldEqual, http.StatusUnauthorized)
+			})
+		})
+
+		Convey("When the metrics are requested with the admin token", func() {
+			request, err := http.NewRequest("GET", firstServer.URL+"/debug/vars", http.NoBody)
+			So(err, ShouldBeNil)
+			request.Header.Set("Authorization", "Bearer secret")
+			response, err := http.DefaultClient.Do(request)
+			So(err, ShouldBeNil)
+			defer response.Body.Close()
+			var vars map[string]json.RawMessage
+			So(json.NewDecoder(response.Body).Decode(&vars), ShouldBeNil)
+
+			Convey("They should include the load on the download limits", func() {
+				So(vars, ShouldContainKey, "memstats")
+				So(string(vars["download_limits"]), ShouldContainSubstring, `"table/xlsx"`)
+			})
+		})
+	})
+}
+
+func TestMount(t *testing.T) {
+	t.Parallel()
+	Convey("Given a service's router with a route of its own", t, func() {
+		router := mux.NewRouter()
+		router.Path("/other").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+		mockDownloader := createMockDownloader("mock", []string{queryParam}, responseBody, http.StatusOK, nil)
+
+		Convey("When the downloaders are mounted on it", func() {
+			Mount(ctx, router, Options{}, Adapt(mockDownloader))
+
+			Convey("Then downloads should be served", func() {
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, httptest.NewRequest("GET", "/download/mock?"+queryParam+"=/a", http.NoBody))
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldEqual, responseBody)
+			})
+
+			Convey("And the service's own routes should still be served", func() {
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, httptest.NewRequest("GET", "/other", http.NoBody))
+				So(w.Code, ShouldEqual, http.StatusTeapot)
+			})
+
+			Convey("And none of the service's endpoints should be routed", func() {
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, httptest.NewRequest("GET", "/health", http.NoBody))
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("When the downloaders are mounted beneath a path prefix", func() {
+			Mount(ctx, router, Options{PathPrefix: "/files/"}, Adapt(mockDownloader))
+
+			Convey("Then downloads should be served beneath it", func() {
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, httptest.NewRequest("GET", "/files/mock?"+queryParam+"=/a", http.NoBody))
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldEqual, responseBody)
+			})
+		})
+
+		Convey("When the downloaders wrap the router", func() {
+			handler := Wrap(ctx, router, Options{}, Adapt(mockDownloader))
+
+			Convey("Then downloads should be served", func() {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest("GET", "/download/mock?"+queryParam+"=/a", http.NoBody))
+				So(w.Code, ShouldEqual, http.StatusOK)
+			})
+
+			Convey("And every other request should be passed on to the router", func() {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest("GET", "/other", http.NoBody))
+				So(w.Code, ShouldEqual, http.StatusTeapot)
+			})
+
+			Convey("And requests with other methods on the paths of downloads should be passed on to the router", func() {
+				router.Path("/download/mock").Methods("POST").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusAccepted)
+				})
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest("POST", "/download/mock?"+queryParam+"=/a", http.NoBody))
+				So(w.Code, ShouldEqual, http.StatusAccepted)
+			})
+		})
+	})
+}
+
+func TestHead(t *testing.T) {
+	t.Parallel()
+	Convey("Given an api with a download cache", t, func() {
+		mockDownloader := createMockDownloader("mock", []string{uriParam}, responseBody, http.StatusOK, nil)
+		api := routes(ctx, &DownloaderAPI{
+			router:        mux.NewRouter(),
+			pathPrefix:    defaultPathPrefix,
+			cache:         cache.NewMemory(1024, time.Hour),
+			maxEntryBytes: 1024,
+		}, &hcMock, Adapt(mockDownloader))
+
+		request := func(method string) *httptest.ResponseRecorder {
+			r, err := http.NewRequest(method, baseURL+"mock?"+uriParam+"=/foo/bar&format=csv", http.NoBody)
+			So(err, ShouldBeNil)
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("When a HEAD request is made for a download that is not cached", func() {
+			w := request("HEAD")
+
+			Convey("Then the headers of the download should be returned without its body", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Content-Type"), ShouldEqual, responseHeaders["Content-Type"])
+				So(w.Header().Get("Content-Disposition"), ShouldEqual, responseHeaders["Content-Disposition"])
+				So(w.Header().Get("Content-Length"), ShouldEqual, strconv.Itoa(len(responseBody)))
+				So(w.Body.Len(), ShouldEqual, 0)
+			})
+
+			Convey("And a following GET should be served from the cache", func() {
+				w := request("GET")
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldEqual, responseBody)
+				So(w.Header().Get("Content-Length"), ShouldEqual, strconv.Itoa(len(responseBody)))
+				So(mockDownloader.DownloadCalls(), ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When a HEAD request is made for a cached download", func() {
+			request("GET")
+			w := request("HEAD")
+
+			Convey("Then it should be answered from the cache without a body", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Content-Length"), ShouldEqual, strconv.Itoa(len(responseBody)))
+				So(w.Body.Len(), ShouldEqual, 0)
+				So(mockDownloader.DownloadCalls(), ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When a HEAD request is made for a download that fails", func() {
+			mockDownloader.DownloadFunc = func(r *http.Request) (io.ReadCloser, map[string]string, int, error) {
+				return nil, nil, http.StatusNotFound, errors.New("not found")
+			}
+			w := request("HEAD")
+
+			Convey("Then the error status should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	})
+}
+
+func TestDigest(t *testing.T) {
+	t.Parallel()
+	Convey("Given an api with a download cache", t, func() {
+		sum := sha256.Sum256([]byte(responseBody))
+		expectedReprDigest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+		expectedDigest := "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+
+		mockDownloader := createMockDownloader("mock", []string{uriParam}, responseBody, http.StatusOK, nil)
+		api := routes(ctx, &DownloaderAPI{
+			router:        mux.NewRouter(),
+			pathPrefix:    defaultPathPrefix,
+			cache:         cache.NewMemory(1024, time.Hour),
+			maxEntryBytes: 1024,
+		}, &hcMock, Adapt(mockDownloader))
+
+		get := func(path string) *http.Response {
+			r, err := http.NewRequest("GET", baseURL+path+"?"+uriParam+"=/foo/bar&format=csv", http.NoBody)
+			So(err, ShouldBeNil)
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+			return w.Result()
+		}
+
+		Convey("When a download of unknown length is requested", func() {
+			resp := get("mock")
+			body, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+
+			Convey("Then it should be streamed, with its digest sent as a trailer", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldEqual, responseBody)
+				So(resp.Header.Get("Content-Length"), ShouldBeEmpty)
+				So(resp.Header.Get("Repr-Digest"), ShouldBeEmpty)
+				So(resp.Trailer.Get("Repr-Digest"), ShouldEqual, expectedReprDigest)
+				So(resp.Trailer.Get("Digest"), ShouldEqual, expectedDigest)
+			})
+
+			Convey("And once it is cached, its length and digest should be sent as headers", func() {
+				resp := get("mock")
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Content-Length"), ShouldEqual, strconv.Itoa(len(responseBody)))
+				So(resp.Header.Get("Repr-Digest"), ShouldEqual, expectedReprDigest)
+				So(resp.Header.Get("Digest"), ShouldEqual, expectedDigest)
+				So(mockDownloader.DownloadCalls(), ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When a download of known length is requested", func() {
+			mockDownloader.DownloadFunc = func(r *http.Request) (io.ReadCloser, map[string]string, int, error) {
+				headers := map[string]string{"Content-Length": strconv.Itoa(len(responseBody))}
+				for key, value := range responseHeaders {
+					headers[key] = value
+				}
+				return io.NopCloser(strings.NewReader(responseBody)), headers, http.StatusOK, nil
+			}
+			resp := get("mock")
+			body, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+
+			Convey("Then its length and digest should be sent as headers", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldEqual, responseBody)
+				So(resp.Header.Get("Content-Length"), ShouldEqual, strconv.Itoa(len(responseBody)))
+				So(resp.Header.Get("Repr-Digest"), ShouldEqual, expectedReprDigest)
+				So(resp.Header.Get("Digest"), ShouldEqual, expectedDigest)
+			})
+		})
+
+		Convey("When the checksum of a download is requested", func() {
+			resp := get("mock.sha256")
+			body, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+
+			Convey("Then it should be returned in the format of sha256sum", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Content-Type"), ShouldEqual, "text/plain; charset=utf-8")
+				So(resp.Header.Get("Content-Disposition"), ShouldEqual, `attachment; filename="fname.ext.sha256"`)
+				So(string(body), ShouldEqual, hex.EncodeToString(sum[:])+"  fname.ext\n")
+			})
+
+			Convey("And the download should be cached", func() {
+				resp := get("mock")
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(mockDownloader.DownloadCalls(), ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When the checksum of a download that fails is requested", func() {
+			mockDownloader.DownloadFunc = func(r *http.Request) (io.ReadCloser, map[string]string, int, error) {
+				return nil, nil, http.StatusNotFound, errors.New("not found")
+			}
+			resp := get("mock.sha256")
+			body, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+
+			Convey("Then the error should be returned", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+				So(strings.TrimSpace(string(body)), ShouldEqual, "not found")
+			})
+		})
+	})
+}
+
+// waitFor polls the condition until it is true, or a second has passed
+func waitFor(condition func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return condition()
+}
+
+func TestCompression(t *testing.T) {
+	t.Parallel()
+	Convey("Given a router with downloads mounted with a download cache and compression", t, func() {
+		compressor, err := compress.New(compress.Config{})
+		So(err, ShouldBeNil)
+		mockDownloader := createMockDownloader("mock", []string{uriParam}, responseBody, http.StatusOK, nil)
+		router := mux.NewRouter()
+		Mount(ctx, router, Options{Cache: cache.NewMemory(1024, time.Hour), MaxCacheEntryBytes: 1024, Compressor: compressor}, Adapt(mockDownloader))
+
+		get := func(path, acceptEncoding string, header ...string) *http.Response {
+			r := httptest.NewRequest("GET", "/download/"+path+"?"+uriParam+"=/foo/bar", http.NoBody)
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+			for i := 0; i+1 < len(header); i += 2 {
+				r.Header.Set(header[i], header[i+1])
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			return w.Result()
+		}
+
+		Convey("When a text download is requested by a client accepting gzip", func() {
+			first := get("mock", "gzip")
+			second := get("mock", "gzip")
+
+			Convey("Then it should be compressed, whether or not it was served from the cache", func() {
+				for _, resp := range []*http.Response{first, second} {
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+					So(resp.Header.Get("Content-Encoding"), ShouldEqual, "gzip")
+					So(resp.Header.Get("Vary"), ShouldEqual, "Accept-Encoding")
+					gz, err := gzip.NewReader(resp.Body)
+					So(err, ShouldBeNil)
+					body, err := io.ReadAll(gz)
+					So(err, ShouldBeNil)
+					So(string(body), ShouldEqual, responseBody)
+				}
+				So(mockDownloader.DownloadCalls(), ShouldHaveLength, 1)
+			})
+
+			Convey("And its checksum should be that of the uncompressed download", func() {
+				resp := get("mock.sha256", "gzip")
+				body, err := io.ReadAll(resp.Body)
+				So(err, ShouldBeNil)
+				sum := sha256.Sum256([]byte(responseBody))
+				So(resp.Header.Get("Content-Encoding"), ShouldBeEmpty)
+				So(string(body), ShouldEqual, hex.EncodeToString(sum[:])+"  fname.ext\n")
+			})
+		})
+
+		Convey("When a cached text download with an ETag is requested again with the ETag of its compressed response", func() {
+			mockDownloader.DownloadFunc = func(r *http.Request) (io.ReadCloser, map[string]string, int, error) {
+				headers := map[string]string{"Content-Type": "text/plain", "ETag": `"v1"`}
+				return io.NopCloser(strings.NewReader(responseBody)), headers, http.StatusOK, nil
+			}
+			first := get("mock", "gzip")
+			second := get("mock", "gzip", "If-None-Match", first.Header.Get("ETag"))
+
+			Convey("Then it should not be modified, with the ETag of the compressed response", func() {
+				So(first.Header.Get("ETag"), ShouldEqual, `"v1-gzip"`)
+				So(second.StatusCode, ShouldEqual, http.StatusNotModified)
+				So(second.Header.Get("ETag"), ShouldEqual, `"v1-gzip"`)
+			})
+
+			Convey("And its checksum should still be served", func() {
+				resp := get("mock.sha256", "gzip", "If-None-Match", first.Header.Get("ETag"))
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When a text download is requested by a client accepting no encoding", func() {
+			resp := get("mock", "")
+			body, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+
+			Convey("Then it should not be compressed", func() {
+				So(resp.Header.Get("Content-Encoding"), ShouldBeEmpty)
+				So(string(body), ShouldEqual, responseBody)
+			})
+		})
+	})
+}
+
+// checkedDownloader is a Downloader that depends on a service whose health it checks
+type checkedDownloader struct {
+	*testdata.DownloaderMock
+}
+
+func (d checkedDownloader) HealthChecks() map[string]healthcheck.Checker {
+	return map[string]healthcheck.Checker{"mock service": func(ctx context.Context, state *healthcheck.CheckState) error {
+		return state.Update(healthcheck.StatusOK, "ok", http.StatusOK)
+	}}
+}
+
+func TestRegistration(t *testing.T) {
+	t.Parallel()
+	Convey("Given a downloader with a route of its own", t, func() {
+		mockDownloader := createMockDownloader("mock", []string{queryParam}, responseBody, http.StatusOK, nil)
+		var middlewareCalls []string
+		middleware := func(name string) mux.MiddlewareFunc {
+			return func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					middlewareCalls = append(middlewareCalls, name)
+					next.ServeHTTP(w, r)
+				})
+			}
+		}
+		d := WithRoute(Adapt(checkedDownloader{mockDownloader}), Route{
+			Methods: []string{http.MethodGet},
+			Paths:   []string{"/{" + queryParam + ":.+}"},
+			Authorise: func(r *http.Request) error {
+				if r.Header.Get("Authorization") != "Bearer secret" {
+					return errors.New("no token")
+				}
+				return nil
+			},
+			Timeout:    time.Millisecond,
+			Middleware: []mux.MiddlewareFunc{middleware("outer"), middleware("inner")},
+		})
+		router := mux.NewRouter()
+		Mount(ctx, router, Options{Timeout: time.Hour}, d)
+
+		serve := func(method, path string, authorised bool) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(method, path, http.NoBody)
+			if authorised {
+				r.Header.Set("Authorization", "Bearer secret")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("When an authorised request is made on one of its paths", func() {
+			w := serve("GET", "/download/mock/economy/mytable", true)
+
+			Convey("Then it should be served through its middleware, outermost first", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldEqual, responseBody)
+				So(middlewareCalls, ShouldResemble, []string{"outer", "inner"})
+				So(mockDownloader.DownloadCalls(), ShouldHaveLength, 1)
+				So(mux.Vars(mockDownloader.DownloadCalls()[0].R)[queryParam], ShouldEqual, "economy/mytable")
+			})
+		})
+
+		Convey("When a request is made without authorisation", func() {
+			w := serve("GET", "/download/mock?"+queryParam+"=/a", false)
+
+			Convey("Then it should be rejected with a 401, without downloading", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(mockDownloader.DownloadCalls(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a request is made with a method it does not declare", func() {
+			w := serve("HEAD", "/download/mock?"+queryParam+"=/a", true)
+
+			Convey("Then it should not be routed", func() {
+				So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			})
+		})
+
+		Convey("When a download takes longer than its route's deadline", func() {
+			mockDownloader.DownloadFunc = func(r *http.Request) (io.ReadCloser, map[string]string, int, error) {
+				<-r.Context().Done()
+				return nil, nil, 0, r.Context().Err()
+			}
+			w := serve("GET", "/download/mock?"+queryParam+"=/a", true)
+
+			Convey("Then it should time out, despite the longer default deadline", func() {
+				So(w.Code, ShouldEqual, http.StatusGatewayTimeout)
+			})
+		})
+
+		Convey("When its health checks are added to a health check", func() {
+			hc := healthcheck.New(healthcheck.VersionInfo{}, time.Minute, time.Minute)
+			So(AddHealthChecks(&hc, d, Adapt(mockDownloader)), ShouldBeNil)
+
+			Convey("Then the check of the service it depends on should be added", func() {
+				So(hc.Checks, ShouldHaveLength, 1)
+				check, err := json.Marshal(hc.Checks[0])
+				So(err, ShouldBeNil)
+				So(string(check), ShouldContainSubstring, `"name":"mock service"`)
+			})
+		})
+	})
+}
+
+// fileDownloader is a Downloader that returns the result or error it is given, with responseBody as its body unless
+// it is empty
+type fileDownloader struct {
+	result   Result
+	empty    bool
+	err      error
+	requests []*Request
+}
+
+func (d *fileDownloader) Download(ctx context.Context, req *Request) (*Result, error) {
+	d.requests = append(d.requests, req)
+	if d.err != nil {
+		return nil, d.err
+	}
+	result := d.result
+	result.Body = io.NopCloser(strings.NewReader(responseBody))
+	if d.empty {
+		result.Body = http.NoBody
+	}
+	return &result, nil
+}
+
+func (d *fileDownloader) Type() string {
+	return "file"
+}
+
+func (d *fileDownloader) QueryParameters() []string {
+	return []string{"uri", "format"}
+}
+
+func TestDownloader(t *testing.T) {
+	t.Parallel()
+	Convey("Given an api with a download cache and a Downloader returning a typed result", t, func() {
+		modified := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		d := &fileDownloader{result: Result{
+			Size:        int64(len(responseBody)),
+			ContentType: "text/csv",
+			Filename:    "bar.csv",
+			ModTime:     modified,
+			ETag:        "v1",
+			Header:      http.Header{"Link": {"</a>; rel=preload", "</b>; rel=preload"}},
+		}}
+		router := mux.NewRouter()
+		Mount(ctx, router, Options{Cache: cache.NewMemory(1024, time.Hour), MaxCacheEntryBytes: 1024}, d)
+
+		get := func(query string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest("GET", "/download/file?"+query, http.NoBody)
+			r.Host = "cy.localhost"
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("When a file is downloaded", func() {
+			w := get("uri=/foo/bar&format=csv")
+
+			Convey("Then it should be described by the headers of the result", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldEqual, responseBody)
+				So(w.Header().Get("Content-Type"), ShouldEqual, "text/csv")
+				So(w.Header().Get("Content-Length"), ShouldEqual, strconv.Itoa(len(responseBody)))
+				So(w.Header().Get("Content-Disposition"), ShouldEqual, `attachment; filename="bar.csv"`)
+				So(w.Header().Get("Last-Modified"), ShouldEqual, "Fri, 02 Jan 2026 03:04:05 GMT")
+				So(w.Header().Get("ETag"), ShouldEqual, `"v1"`)
+				So(w.Header().Values("Link"), ShouldResemble, []string{"</a>; rel=preload", "</b>; rel=preload"})
+			})
+
+			Convey("And the Downloader should be given the parsed request", func() {
+				So(d.requests, ShouldHaveLength, 1)
+				So(d.requests[0].Method, ShouldEqual, "GET")
+				So(d.requests[0].Params.Get("uri"), ShouldEqual, "/foo/bar")
+				So(d.requests[0].Params.Get("format"), ShouldEqual, "csv")
+				So(d.requests[0].Lang, ShouldEqual, "cy")
+				So(d.requests[0].CollectionID, ShouldBeEmpty)
+			})
+
+			Convey("And downloading it again should be served from the cache, with the same headers", func() {
+				w := get("uri=/foo/bar&format=csv")
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("ETag"), ShouldEqual, `"v1"`)
+				So(w.Header().Get("Link"), ShouldEqual, "</a>; rel=preload, </b>; rel=preload")
+				So(d.requests, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When an empty file is downloaded", func() {
+			d.result.Size = 0
+			d.result.Empty = true
+			d.empty = true
+			w := get("uri=/foo/bar&format=csv")
+
+			Convey("Then it should be sent with its length of zero", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Content-Length"), ShouldEqual, "0")
+				So(w.Body.Len(), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a file is downloaded without its size", func() {
+			d.result.Size = 0
+			w := get("uri=/foo/bar&format=csv")
+
+			Convey("Then it should be sent in full without a Content-Length", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Content-Length"), ShouldBeEmpty)
+				So(w.Body.String(), ShouldEqual, responseBody)
+			})
+		})
+
+		Convey("When a file is requested in the language of a lang cookie", func() {
+			request := func(header http.Header) {
+				r := httptest.NewRequest("GET", "/download/file?uri=/foo/bar&format=csv", http.NoBody)
+				r.Header = header
+				r.AddCookie(&http.Cookie{Name: "lang", Value: "cy"})
+				router.ServeHTTP(httptest.NewRecorder(), r)
+			}
+			request(http.Header{})
+			request(http.Header{"Collection-Id": {"my-collection"}})
+
+			Convey("Then the cookie should only choose the language of private downloads, which shared caches don't store", func() {
+				So(d.requests, ShouldHaveLength, 2)
+				So(d.requests[0].Lang, ShouldEqual, "en")
+				So(d.requests[1].Lang, ShouldEqual, "cy")
+				So(d.requests[1].CollectionID, ShouldEqual, "my-collection")
+			})
+		})
+
+		Convey("When a file is requested without a required parameter", func() {
+			w := get("uri=/foo/bar")
+
+			Convey("Then a 400 should be returned without calling the Downloader", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(w.Body.String(), ShouldContainSubstring, "missing required query parameters: format")
+				So(d.requests, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the Downloader fails with an Error", func() {
+			d.err = &Error{Status: http.StatusNotFound, Err: errors.New("no such table")}
+			w := get("uri=/foo/bar&format=csv")
+
+			Convey("Then its status should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+				So(w.Body.String(), ShouldEqual, "no such table\n")
+			})
+		})
+
+		Convey("When the Downloader fails with any other error", func() {
+			d.err = errors.New("broken")
+			w := get("uri=/foo/bar&format=csv")
+
+			Convey("Then a 500 should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusInternalServerError)
+			})
+		})
+	})
+}
+
+// pathRoutedDownloader is a LegacyDownloader declaring path-style downloads of csv files as a PathRouted
+type pathRoutedDownloader struct {
+	*testdata.DownloaderMock
+}
+
+func (pathRoutedDownloader) Paths() []string {
+	return []string{"/{path:.+}"}
+}
+
+func (pathRoutedDownloader) ResolvePath(vars map[string]string) (url.Values, error) {
+	uri, ok := strings.CutSuffix(vars["path"], ".csv")
+	if !ok {
+		return nil, errors.New("unknown extension")
+	}
+	return url.Values{uriParam: {"/" + uri}}, nil
+}
+
+func (pathRoutedDownloader) CanonicalPath(params url.Values) (string, url.Values, bool) {
+	return strings.TrimPrefix(params.Get(uriParam), "/") + ".csv", url.Values{}, true
+}
+
+func TestPathRoutedLegacyDownloader(t *testing.T) {
+	t.Parallel()
+	Convey("Given an adapted LegacyDownloader declaring its paths as a PathRouted", t, func() {
+		mockDownloader := pathRoutedDownloader{createMockDownloader("mock", []string{uriParam}, responseBody, http.StatusOK, nil)}
+		router := mux.NewRouter()
+		Mount(ctx, router, Options{}, Adapt(mockDownloader))
+
+		Convey("When a file is downloaded at its path", func() {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/download/mock/foo/bar.csv", http.NoBody))
+
+			Convey("Then it should be downloaded with the resolved parameters, and its canonical path advertised", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldEqual, responseBody)
+				So(mockDownloader.DownloadCalls(), ShouldHaveLength, 1)
+				So(mockDownloader.DownloadCalls()[0].R.URL.Query().Get(uriParam), ShouldEqual, "/foo/bar")
+				So(w.Header().Get("Link"), ShouldEqual, `</download/mock/foo/bar.csv>; rel="canonical"`)
+			})
+		})
+	})
+}
+
+func TestPathRoute(t *testing.T) {
+	t.Parallel()
+	Convey("Given a Downloader with a route resolving paths with a file extension", t, func() {
+		d := &fileDownloader{result: Result{Size: int64(len(responseBody)), ContentType: "text/csv"}}
+		route := Route{
+			Paths: []string{"/{path:.+}"},
+			Resolve: func(vars map[string]string) (url.Values, error) {
+				uri, ok := strings.CutSuffix(vars["path"], ".csv")
+				if !ok {
+					return nil, errors.New("unknown extension")
+				}
+				return url.Values{"uri": {"/" + uri}, "format": {"csv"}}, nil
+			},
+			Canonical: func(params url.Values) (string, url.Values, bool) {
+				query := url.Values{}
+				for name, values := range params {
+					if name != "uri" && name != "format" {
+						query[name] = values
+					}
+				}
+				return strings.TrimPrefix(params.Get("uri"), "/") + "." + params.Get("format"), query, true
+			},
+		}
+		router := mux.NewRouter()
+		Mount(ctx, router, Options{Cache: cache.NewMemory(1024, time.Hour), MaxCacheEntryBytes: 1024}, WithRoute(d, route))
+
+		get := func(target string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", target, http.NoBody))
+			return w
+		}
+
+		Convey("When a file is downloaded at its path", func() {
+			w := get("/download/file/economy/mytable.csv?lang=cy")
+
+			Convey("Then the Downloader should be given the resolved parameters", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldEqual, responseBody)
+				So(d.requests, ShouldHaveLength, 1)
+				So(d.requests[0].Params.Get("uri"), ShouldEqual, "/economy/mytable")
+				So(d.requests[0].Params.Get("format"), ShouldEqual, "csv")
+				So(d.requests[0].Params.Get("lang"), ShouldEqual, "cy")
+			})
+
+			Convey("And the canonical path should be advertised", func() {
+				So(w.Header().Get("Link"), ShouldEqual, `</download/file/economy/mytable.csv?lang=cy>; rel="canonical"`)
+			})
+
+			Convey("And the same file requested with a query should be served from the cache", func() {
+				w := get("/download/file?format=csv&lang=cy&uri=/economy/mytable")
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldEqual, responseBody)
+				So(w.Header().Get("Link"), ShouldEqual, `</download/file/economy/mytable.csv?lang=cy>; rel="canonical"`)
+				So(d.requests, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When the checksum of a file is requested at its path", func() {
+			w := get("/download/file/economy/mytable.csv.sha256")
+
+			Convey("Then the checksum of the download should be returned", func() {
+				sum := sha256.Sum256([]byte(responseBody))
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldStartWith, hex.EncodeToString(sum[:]))
+				So(d.requests[0].Params.Get("uri"), ShouldEqual, "/economy/mytable")
+			})
+		})
+
+		Convey("When a path that can't be resolved is requested", func() {
+			w := get("/download/file/economy/mytable.pdf")
+
+			Convey("Then a 404 should be returned without calling the Downloader", func() {
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+				So(d.requests, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
 func createMockDownloader(path string, query []string, responseBody string, code int, err error) *testdata.DownloaderMock {
 	return &testdata.DownloaderMock{
 		QueryParametersFunc: func() []string {
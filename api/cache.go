@@ -0,0 +1,226 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ONSdigital/dp-file-downloader/cache"
+	"github.com/ONSdigital/dp-net/v3/request"
+	"github.com/ONSdigital/log.go/v2/log"
+)
+
+// uriParam is the query parameter identifying the downloaded content, which downloads are cached and invalidated by
+const uriParam = "uri"
+
+// invalidateRequest is the body of a request to invalidate cached downloads
+type invalidateRequest struct {
+	URIs     []string `json:"uris"`
+	Prefixes []string `json:"prefixes"`
+}
+
+// invalidateResponse is the body of the response to a request to invalidate cached downloads
+type invalidateResponse struct {
+	Invalidated int `json:"invalidated"`
+}
+
+// warmRequest is the body of a request to warm cached downloads
+type warmRequest struct {
+	URIs []string `json:"uris"`
+}
+
+// warmResponse is the body of the response to a request to warm cached downloads
+type warmResponse struct {
+	Queued int `json:"queued"`
+}
+
+// cacheKey returns the key a download is cached under, and false if it must not be cached.
+// Every parameter other than the uri, along with the language, distinguishes variants of the same uri.
+func (api *DownloaderAPI) cacheKey(r *http.Request, params url.Values, downloaderType string) (cache.Key, bool) {
+	uri := params.Get(uriParam)
+	if api.cache == nil || uri == "" || isPrivate(r) {
+		return cache.Key{}, false
+	}
+
+	variants := make(url.Values, len(params))
+	for name, values := range params {
+		if name != uriParam {
+			variants[name] = values
+		}
+	}
+	variant := variants.Encode() // sorted by key
+	return cache.NewKey(downloaderType, uri, variant+"|"+request.GetLocaleCode(r)), true
+}
+
+// hostLanguage returns the request without its lang cookie, unless it is private, so that a published download is in
+// the language of its host (e.g. Welsh on cy.ons.gov.uk). Shared caches store published downloads under their host and
+// url, so their language must not depend on a cookie that the caches don't key on.
+func hostLanguage(r *http.Request) *http.Request {
+	if _, err := r.Cookie(request.LocaleCookieKey); err != nil || isPrivate(r) {
+		return r
+	}
+	cookies := r.Cookies()
+	r = r.Clone(r.Context())
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != request.LocaleCookieKey {
+			r.AddCookie(cookie)
+		}
+	}
+	return r
+}
+
+// writeCachedDownload writes a cached download to the response, applying the current cache policy to it, and closes
+// the entry. Only the headers are written in response to a HEAD request. Conditional and range requests are answered
+// against the cached download's ETag and Last-Modified headers.
+func (api *DownloaderAPI) writeCachedDownload(w http.ResponseWriter, r *http.Request, downloaderType string, entry *cache.Entry) {
+	defer entry.Close()
+	for key, value := range entry.Header {
+		w.Header().Set(key, value)
+	}
+	api.setCacheHeaders(w, r, downloaderType, entry.Status)
+	// entries cached before digests were stored don't have one
+	if w.Header().Get(reprDigestHeader) == "" {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, entry.Reader()); err != nil {
+			log.Error(r.Context(), "writeCachedDownload: Error while reading cached download", err, log.Data{"url": r.URL.String()})
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		setDigestHeaders(w.Header(), hash.Sum(nil))
+	}
+	if entry.Status == http.StatusOK {
+		modTime, _ := http.ParseTime(w.Header().Get("Last-Modified"))
+		http.ServeContent(w, r, "", modTime, entry.Reader())
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(entry.Size(), 10))
+	w.WriteHeader(entry.Status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, entry.Reader()); err != nil {
+		log.Error(r.Context(), "writeCachedDownload: Error while writing cached download", err, log.Data{"url": r.URL.String()})
+	}
+}
+
+// storeDownload caches a successful download along with its digest. Failures are logged, as the download has already been served.
+// Repeated headers are stored as a single comma-separated header.
+func (api *DownloaderAPI) storeDownload(ctx context.Context, key cache.Key, headers http.Header, body []byte, sum []byte) {
+	entryHeaders := make(map[string]string, len(headers)+2)
+	for name, values := range headers {
+		entryHeaders[name] = strings.Join(values, ", ")
+	}
+	entryHeaders[reprDigestHeader] = reprDigest(sum)
+	entryHeaders[digestHeader] = legacyDigest(sum)
+	entry := &cache.Entry{Status: http.StatusOK, Header: entryHeaders, Body: body}
+	if err := api.cache.Set(ctx, key, entry); err != nil {
+		log.Error(ctx, "storeDownload: Error while caching download", err, log.Data{"key": key.String()})
+	}
+}
+
+// handleInvalidate removes cached downloads of the requested uris, and of every uri starting with the requested prefixes
+func (api *DownloaderAPI) handleInvalidate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var body invalidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body.URIs) == 0 && len(body.Prefixes) == 0 {
+		http.Error(w, "at least one uri or prefix must be provided", http.StatusBadRequest)
+		return
+	}
+
+	invalidated := 0
+	for _, targets := range []struct {
+		values []string
+		prefix bool
+	}{{body.URIs, false}, {body.Prefixes, true}} {
+		for _, uri := range targets.values {
+			n, err := api.cache.Invalidate(ctx, uri, targets.prefix)
+			if err != nil {
+				log.Error(ctx, "handleInvalidate: Error invalidating cached downloads", err, log.Data{"uri": uri, "prefix": targets.prefix})
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			invalidated += n
+		}
+	}
+
+	log.Info(ctx, "invalidated cached downloads", log.Data{"uris": body.URIs, "prefixes": body.Prefixes, "invalidated": invalidated})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(invalidateResponse{Invalidated: invalidated}); err != nil {
+		log.Error(ctx, "handleInvalidate: Error writing response", err)
+	}
+}
+
+// handleWarm queues every configured format and language of the requested uris to be rendered into the cache
+func (api *DownloaderAPI) handleWarm(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var body warmRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body.URIs) == 0 {
+		http.Error(w, "at least one uri must be provided", http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusAccepted
+	queued, err := api.warmer.Warm(ctx, body.URIs)
+	if err != nil {
+		log.Error(ctx, "handleWarm: Error queueing downloads to warm", err, log.Data{"uris": body.URIs, "queued": queued})
+		status = http.StatusServiceUnavailable
+	}
+
+	log.Info(ctx, "queued downloads to warm", log.Data{"uris": body.URIs, "queued": queued})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err = json.NewEncoder(w).Encode(warmResponse{Queued: queued}); err != nil {
+		log.Error(ctx, "handleWarm: Error writing response", err)
+	}
+}
+
+// authorised wraps an admin handler so that it is only invoked for requests with the admin bearer token
+func (api *DownloaderAPI) authorised(handler http.HandlerFunc) http.HandlerFunc {
+	expected := []byte("Bearer " + api.adminToken)
+	return func(w http.ResponseWriter, r *http.Request) {
+		actual := []byte(strings.TrimSpace(r.Header.Get("Authorization")))
+		if subtle.ConstantTimeCompare(actual, expected) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// cappedBuffer captures up to limit bytes written to it. Writes never fail, so that the download is still
+// served in full when it is too large to cache.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit      int64
+	overflowed bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.overflowed || int64(b.Len()+len(p)) > b.limit {
+		b.overflowed = true
+		b.Reset()
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// renderContext is done once its parent is done, or once its deadline has passed unless the deadline was stopped first.
+// It is like a context with a timeout, reporting context.DeadlineExceeded when it expires, but lets a download's deadline
+// bound rendering it without bounding writing it to the client, which HTTP_WRITE_TIMEOUT bounds.
+type renderContext struct {
+	context.Context
+	done chan struct{}
+	once sync.Once
+	mu   sync.Mutex
+	err  error
+}
+
+// withRenderDeadline returns a context that expires after the timeout, unless stop is called first, and a function
+// that cancels it. The context has no deadline if the timeout is zero.
+func withRenderDeadline(parent context.Context, timeout time.Duration) (ctx context.Context, stop func(), cancel func()) {
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(parent)
+		return ctx, func() {}, cancel
+	}
+
+	c := &renderContext{Context: parent, done: make(chan struct{})}
+	timer := time.AfterFunc(timeout, func() { c.cancel(context.DeadlineExceeded) })
+	stopParent := context.AfterFunc(parent, func() { c.cancel(parent.Err()) })
+	return c, func() { timer.Stop() }, func() {
+		timer.Stop()
+		stopParent()
+		c.cancel(context.Canceled)
+	}
+}
+
+func (c *renderContext) cancel(err error) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		close(c.done)
+	})
+}
+
+func (c *renderContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *renderContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/ONSdigital/log.go/v2/log"
+)
+
+const (
+	reprDigestHeader = "Repr-Digest"
+	digestHeader     = "Digest"
+	checksumSuffix   = ".sha256"
+	// maxBufferedBytes is the largest download of known length that is read in full before it is written, so that its
+	// digest can be sent as a header. Larger downloads are streamed without a digest; their checksum can be requested
+	// from the checksum endpoint.
+	maxBufferedBytes = 16 << 20
+)
+
+// reprDigest returns the value of the Repr-Digest header (RFC 9530) for the SHA-256 sum of a download
+func reprDigest(sum []byte) string {
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum) + ":"
+}
+
+// legacyDigest returns the value of the Digest header (RFC 3230) for the SHA-256 sum of a download, for clients
+// that don't yet support Repr-Digest
+func legacyDigest(sum []byte) string {
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum)
+}
+
+func setDigestHeaders(h http.Header, sum []byte) {
+	h.Set(reprDigestHeader, reprDigest(sum))
+	h.Set(digestHeader, legacyDigest(sum))
+}
+
+// isBufferable returns true if the download declares its length, and is small enough to be read in full before it is written
+func isBufferable(res *Result) bool {
+	size, known := res.size()
+	return known && size <= maxBufferedBytes
+}
+
+// handleChecksum serves the SHA-256 checksum of a download in the format of sha256sum. The download is made as a
+// HEAD request would make it, so it is served from the cache or cached as usual, and its checksum is taken from the
+// digest. Downloads that fail are responded to as they would be for a GET request.
+func (api *DownloaderAPI) handleChecksum(d Downloader) func(http.ResponseWriter, *http.Request) {
+	download := api.handleDownload(d)
+	return func(w http.ResponseWriter, r *http.Request) {
+		head := r.Clone(r.Context())
+		head.Method = http.MethodHead
+		// the checksum is of the whole download, whatever the client holds
+		for _, name := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range", "Range"} {
+			head.Header.Del(name)
+		}
+		rw := &bufferedResponse{header: make(http.Header)}
+		download(rw, head)
+
+		if rw.status != http.StatusOK {
+			for name, values := range rw.header {
+				w.Header()[name] = values
+			}
+			w.Header().Del("Content-Length")
+			w.WriteHeader(rw.status)
+			if _, err := w.Write(rw.body.Bytes()); err != nil {
+				log.Error(r.Context(), "handleChecksum: Error while writing response", err, log.Data{"request:": r})
+			}
+			return
+		}
+
+		sum, ok := parseReprDigest(rw.header.Get(reprDigestHeader))
+		if !ok {
+			http.Error(w, "download has no digest", http.StatusInternalServerError)
+			return
+		}
+
+		filename := "download"
+		if _, params, err := mime.ParseMediaType(rw.header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+			filename = params["filename"]
+		}
+
+		// the caching and preview headers of the download apply equally to its checksum
+		for _, name := range []string{"Cache-Control", "Surrogate-Control", "X-Content-Preview", "X-Robots-Tag"} {
+			if values, ok := rw.header[name]; ok {
+				w.Header()[name] = values
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+checksumSuffix+"\"")
+		fmt.Fprintf(w, "%x  %s\n", sum, filename)
+	}
+}
+
+// parseReprDigest returns the SHA-256 sum in a Repr-Digest header written by setDigestHeaders
+func parseReprDigest(value string) ([]byte, bool) {
+	encoded, ok := strings.CutPrefix(value, "sha-256=:")
+	if !ok {
+		return nil, false
+	}
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(encoded, ":"))
+	return sum, err == nil && len(sum) == sha256.Size
+}
+
+// bufferedResponse is a http.ResponseWriter that holds the response written to it, to be passed on
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *bufferedResponse) Header() http.Header {
+	return rw.header
+}
+
+func (rw *bufferedResponse) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+}
+
+func (rw *bufferedResponse) Write(p []byte) (int, error) {
+	rw.WriteHeader(http.StatusOK)
+	return rw.body.Write(p)
+}
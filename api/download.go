@@ -0,0 +1,262 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-healthcheck/healthcheck"
+	dphandlers "github.com/ONSdigital/dp-net/v3/handlers"
+	"github.com/ONSdigital/dp-net/v3/request"
+	"github.com/gorilla/mux"
+)
+
+// Downloader creates the files of a type of download
+type Downloader interface {
+	// Download retrieves/creates the requested file. The api closes the body of the result.
+	// A download that fails returns an error, which is responded to with the status of an *Error, or a 500.
+	Download(ctx context.Context, req *Request) (*Result, error)
+	// Type returns the (conceptual) type of file downloaded - forms part of the request path handled by this Downloader
+	Type() string
+	// QueryParameters returns the names of query parameters required by this Downloader
+	QueryParameters() []string
+}
+
+// Request is a request for a download, parsed and validated by the api
+type Request struct {
+	// Method is GET or HEAD. The body of the result is read in response to both.
+	Method string
+	// Params are the query parameters of the request and the variables of its path. Every parameter returned by
+	// the Downloader's QueryParameters is present.
+	Params url.Values
+	Header http.Header
+	// CollectionID identifies the collection of unpublished content requested, if any
+	CollectionID string
+	Lang         string
+	// AccessToken is the Florence user's token, if the request was made by one
+	AccessToken string
+
+	// http is the request the download was parsed from, passed on to LegacyDownloaders
+	http *http.Request
+}
+
+// Result is a file created by a Downloader
+type Result struct {
+	// Body is the content of the file
+	Body io.ReadCloser
+	// Size is the length of Body in bytes. If it is not positive the length is unknown, and the file is streamed
+	// without a Content-Length, unless Empty is set.
+	Size int64
+	// Empty marks a file known to have no content, which is sent with a Content-Length of zero
+	Empty       bool
+	ContentType string
+	// Filename is the name the file is saved as by clients, if it should be downloaded as an attachment
+	Filename string
+	// CacheControl replaces the Cache-Control header of the api's cache policy, if set. Files with their own
+	// Cache-Control, such as previews of unpublished content, are never stored in the api's cache.
+	CacheControl string
+	// ModTime is the time the file was last modified, sent as Last-Modified if it is set
+	ModTime time.Time
+	// ETag is the entity tag of the file. It is quoted, unless it already is.
+	ETag string
+	// Status is the status of the response. Defaults to 200. Results with another status, e.g. the error page of a
+	// service the download depends on, are passed on but never cached.
+	Status int
+	// Header holds any other headers of the response
+	Header http.Header
+}
+
+// Error is a download that failed with the status it is responded to with
+type Error struct {
+	Status int
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// statusOf returns the status a failed download is responded to with: 504 if its deadline was exceeded, else that of
+// an *Error if it is a client or server error, or 500
+func statusOf(ctx context.Context, err error) int {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	var downloadErr *Error
+	if errors.As(err, &downloadErr) && downloadErr.Status >= http.StatusBadRequest {
+		return downloadErr.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// newRequest parses a request for a download, resolving the variables of its path with the route. A *Error is
+// returned if the path can't be resolved or any of the Downloader's required parameters are missing.
+func newRequest(r *http.Request, d Downloader, route Route) (*Request, error) {
+	params := r.URL.Query()
+	if vars := mux.Vars(r); len(vars) > 0 {
+		resolved := make(url.Values, len(vars))
+		if route.Resolve != nil {
+			var err error
+			if resolved, err = route.Resolve(vars); err != nil {
+				return nil, &Error{Status: http.StatusNotFound, Err: err}
+			}
+		} else {
+			for name, value := range vars {
+				resolved.Set(name, value)
+			}
+		}
+		for name, values := range resolved {
+			params[name] = values
+		}
+	}
+
+	var missing []string
+	for _, name := range d.QueryParameters() {
+		if params.Get(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &Error{Status: http.StatusBadRequest, Err: fmt.Errorf("missing required query parameters: %s", strings.Join(missing, ", "))}
+	}
+
+	collectionID, _ := request.GetCollectionID(r)
+	accessToken, _ := dphandlers.GetFlorenceToken(r.Context(), r)
+	return &Request{
+		Method:       r.Method,
+		Params:       params,
+		Header:       r.Header,
+		CollectionID: collectionID,
+		Lang:         request.GetLocaleCode(r),
+		AccessToken:  accessToken,
+		http:         r,
+	}, nil
+}
+
+// status returns the status of the response to the result
+func (res *Result) status() int {
+	if res.Status == 0 {
+		return http.StatusOK
+	}
+	return res.Status
+}
+
+// size returns the length of the result's body, and false if it is unknown
+func (res *Result) size() (int64, bool) {
+	if res.Empty {
+		return 0, true
+	}
+	return res.Size, res.Size > 0
+}
+
+// header returns the headers of the response to the result
+func (res *Result) header() http.Header {
+	h := res.Header.Clone()
+	if h == nil {
+		h = make(http.Header)
+	}
+	if res.ContentType != "" {
+		h.Set("Content-Type", res.ContentType)
+	}
+	if size, ok := res.size(); ok {
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	if res.Filename != "" {
+		h.Set("Content-Disposition", "attachment; filename=\""+res.Filename+"\"")
+	}
+	if res.CacheControl != "" {
+		h.Set("Cache-Control", res.CacheControl)
+	}
+	if !res.ModTime.IsZero() {
+		h.Set("Last-Modified", res.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if etag := res.ETag; etag != "" {
+		if !strings.HasSuffix(etag, `"`) {
+			etag = `"` + etag + `"`
+		}
+		h.Set("ETag", etag)
+	}
+	return h
+}
+
+// cannot use "go:generate moq -out testdata/mock_downloader.go -pkg testdata . LegacyDownloader" here
+// as moq can't handle build tags and incorrectly believes there are duplicate methods in gorilla/mux
+// https://github.com/matryer/moq/issues/47
+
+// LegacyDownloader is the original interface of a Downloader, which parses the http request itself and describes the
+// file with a map of headers. Adapt it to serve its downloads.
+type LegacyDownloader interface {
+	// Download retrieves/creates the file requested in the http.Request , returning:
+	// body - a reader with the contents of the file. This must be closed by the caller.
+	// headers - should include Content-Type and Content-Disposition
+	// status - the http status code - should be 200 unless there was an error
+	// err - any error that occurred during processing
+	Download(r *http.Request) (body io.ReadCloser, headers map[string]string, status int, err error)
+	// Type returns the (conceptual) type of file downloaded - forms part of the request path handled by this Downloader
+	Type() string
+	// QueryParameters returns the names of query parameters required by this Downloader
+	QueryParameters() []string
+}
+
+// Adapt returns a Downloader that downloads with a LegacyDownloader. The LegacyDownloader receives the http request
+// with the request's parameters, including any path variables, as its query.
+func Adapt(d LegacyDownloader) Downloader {
+	return &legacyDownloader{LegacyDownloader: d}
+}
+
+type legacyDownloader struct {
+	LegacyDownloader
+}
+
+func (d *legacyDownloader) Download(ctx context.Context, req *Request) (*Result, error) {
+	r := req.http.Clone(ctx)
+	r.URL.RawQuery = req.Params.Encode()
+
+	body, headers, status, err := d.LegacyDownloader.Download(r)
+	if err != nil {
+		if body != nil {
+			_ = body.Close()
+		}
+		return nil, &Error{Status: status, Err: err}
+	}
+
+	res := &Result{Body: body, Status: status, Header: make(http.Header, len(headers))}
+	for name, value := range headers {
+		switch http.CanonicalHeaderKey(name) {
+		case "Content-Type":
+			res.ContentType = value
+		case "Content-Length":
+			if size, err := strconv.ParseInt(value, 10, 64); err == nil {
+				res.Size, res.Empty = size, size == 0
+			}
+		case "Cache-Control":
+			res.CacheControl = value
+		default:
+			res.Header.Set(name, value)
+		}
+	}
+	return res, nil
+}
+
+// Route passes on the route of the LegacyDownloader, if it declares one
+func (d *legacyDownloader) Route() Route {
+	return declaredRoute(d.LegacyDownloader)
+}
+
+// HealthChecks passes on the checkers of the LegacyDownloader, if it declares any
+func (d *legacyDownloader) HealthChecks() map[string]healthcheck.Checker {
+	if hc, ok := d.LegacyDownloader.(HealthChecked); ok {
+		return hc.HealthChecks()
+	}
+	return nil
+}
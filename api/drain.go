@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ONSdigital/dp-healthcheck/healthcheck"
+	"github.com/ONSdigital/log.go/v2/log"
+)
+
+// drainPollInterval is how often Drain checks whether in-flight downloads have completed
+const drainPollInterval = 50 * time.Millisecond
+
+// activeDownload is a download in progress, which can be aborted if it doesn't complete while draining
+type activeDownload struct {
+	downloaderType string
+	url            string
+	started        time.Time
+	cancel         context.CancelFunc
+}
+
+// track records the download as in progress until done is called, returning a context that is cancelled if the
+// download is aborted
+func (api *DownloaderAPI) track(r *http.Request, downloaderType string) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(r.Context())
+	download := &activeDownload{downloaderType: downloaderType, url: r.URL.String(), started: time.Now(), cancel: cancel}
+
+	api.activeMu.Lock()
+	if api.active == nil {
+		api.active = make(map[*activeDownload]struct{})
+	}
+	api.active[download] = struct{}{}
+	api.activeMu.Unlock()
+
+	return ctx, func() {
+		api.activeMu.Lock()
+		delete(api.active, download)
+		api.activeMu.Unlock()
+		cancel()
+	}
+}
+
+// activeCount returns the number of downloads in progress
+func (api *DownloaderAPI) activeCount() int {
+	api.activeMu.Lock()
+	defer api.activeMu.Unlock()
+	return len(api.active)
+}
+
+// Drain prepares the api to be shut down without cutting off downloads. The health endpoint reports the service as
+// unavailable, then after the drain delay new downloads are rejected. In-flight downloads are given until the drain
+// timeout (or until the context is done) to complete, after which any still in progress are aborted and logged.
+func (api *DownloaderAPI) Drain(ctx context.Context) {
+	api.unhealthy.Store(true)
+	log.Info(ctx, "draining: reporting unhealthy", log.Data{"delay": api.drainDelay, "in_flight": api.activeCount()})
+	if api.drainDelay > 0 {
+		select {
+		case <-time.After(api.drainDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	api.draining.Store(true)
+	log.Info(ctx, "draining: rejecting new downloads", log.Data{"timeout": api.drainTimeout, "in_flight": api.activeCount()})
+
+	deadline := time.NewTimer(api.drainTimeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for api.activeCount() > 0 {
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			api.abortActive(ctx)
+			return
+		case <-ctx.Done():
+			api.abortActive(ctx)
+			return
+		}
+	}
+	log.Info(ctx, "draining: all downloads complete")
+}
+
+// abortActive cancels the downloads still in progress, logging each of them
+func (api *DownloaderAPI) abortActive(ctx context.Context) {
+	api.activeMu.Lock()
+	defer api.activeMu.Unlock()
+	for download := range api.active {
+		log.Warn(ctx, "draining: aborting download that did not complete in time", log.Data{
+			"type":     download.downloaderType,
+			"url":      download.url,
+			"duration": time.Since(download.started).String(),
+		})
+		download.cancel()
+	}
+}
+
+// handleHealth reports the service as unavailable while it is draining, and otherwise defers to the health check
+func (api *DownloaderAPI) handleHealth(hc *healthcheck.HealthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if api.unhealthy.Load() {
+			w.Header().Set("Cache-Control", "no-store")
+			http.Error(w, "service is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		hc.Handler(w, r)
+	}
+}
+
+// rejectDraining responds to a download requested while the api is draining, so that the client retries elsewhere
+func (api *DownloaderAPI) rejectDraining(w http.ResponseWriter, r *http.Request, downloaderType string) {
+	w.Header().Set("Connection", "close")
+	w.Header().Set("Retry-After", strconv.Itoa(int(api.retryAfter.Seconds())))
+	api.setCacheHeaders(w, r, downloaderType, http.StatusServiceUnavailable)
+	http.Error(w, "service is shutting down", http.StatusServiceUnavailable)
+}
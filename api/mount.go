@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-file-downloader/cache"
+	"github.com/ONSdigital/dp-file-downloader/compress"
+	"github.com/ONSdigital/dp-file-downloader/limit"
+	"github.com/ONSdigital/dp-file-downloader/ratelimit"
+	"github.com/ONSdigital/log.go/v2/log"
+	"github.com/gorilla/mux"
+)
+
+const defaultPathPrefix = "/download"
+
+// Options configures how downloads are served by an api. The zero value serves downloads at /download/{type}
+// without caching headers, caching, deadlines, concurrency limits or rate limits.
+type Options struct {
+	// PathPrefix is the path the downloader types are routed beneath. Defaults to /download.
+	PathPrefix string
+	// CachePolicy sets the caching headers of downloads. No caching headers are set if it is nil.
+	CachePolicy *cache.Policy
+	// Cache stores rendered downloads of published content. Downloads are not cached if it is nil.
+	Cache              cache.Cache
+	MaxCacheEntryBytes int64
+	// Timeout is the deadline for rendering a download, unless overridden for the downloader type by TimeoutByType.
+	// Downloads have no deadline if it is zero.
+	Timeout       time.Duration
+	TimeoutByType map[string]time.Duration
+	// Limits bounds the number of downloads rendered at once. Downloads are not limited if it is nil.
+	Limits     *limit.Limits
+	RetryAfter time.Duration
+	// RateLimiter limits the rate of downloads requested by each client. Clients are not rate limited if it is nil.
+	RateLimiter *ratelimit.RateLimiter
+	// Compressor compresses text downloads for clients that accept it. Downloads are not compressed if it is nil.
+	Compressor *compress.Compressor
+	// DrainTimeout is how long Drain waits for in-flight downloads to complete before aborting them
+	DrainTimeout time.Duration
+}
+
+// Mount registers GET and HEAD routes for each of the downloaders on an existing router, with the same caching, limiting and
+// error handling as this service. No server is started, no OpenTelemetry is set up and the service config is not read,
+// so that other services can serve downloads themselves. The returned api can be used to Drain downloads on shutdown.
+func Mount(ctx context.Context, router *mux.Router, opts Options, downloaders ...Downloader) *DownloaderAPI {
+	api := newAPI(router, opts)
+	api.mountDownloads(ctx, downloaders...)
+	return api
+}
+
+// Wrap returns a handler serving the downloaders as Mount does, passing every other request on to next
+func Wrap(ctx context.Context, next http.Handler, opts Options, downloaders ...Downloader) http.Handler {
+	router := mux.NewRouter()
+	router.NotFoundHandler = next
+	router.MethodNotAllowedHandler = next
+	Mount(ctx, router, opts, downloaders...)
+	return router
+}
+
+func newAPI(router *mux.Router, opts Options) *DownloaderAPI {
+	pathPrefix := strings.TrimSuffix(opts.PathPrefix, "/")
+	if pathPrefix == "" {
+		pathPrefix = defaultPathPrefix
+	}
+	return &DownloaderAPI{
+		router:        router,
+		handler:       router,
+		pathPrefix:    pathPrefix,
+		cachePolicy:   opts.CachePolicy,
+		cache:         opts.Cache,
+		maxEntryBytes: opts.MaxCacheEntryBytes,
+		limits:        opts.Limits,
+		retryAfter:    opts.RetryAfter,
+		timeout:       opts.Timeout,
+		timeoutByType: opts.TimeoutByType,
+		rateLimiter:   opts.RateLimiter,
+		compressor:    opts.Compressor,
+		drainTimeout:  opts.DrainTimeout,
+	}
+}
+
+// mountDownloads routes requests for each downloader type to its downloader, as declared by its Route, along with
+// GET requests for the checksums of its downloads
+func (api *DownloaderAPI) mountDownloads(ctx context.Context, downloaders ...Downloader) {
+	for _, d := range downloaders {
+		route := routeOf(d)
+		if route.Timeout > 0 {
+			if api.routeTimeouts == nil {
+				api.routeTimeouts = make(map[string]time.Duration)
+			}
+			api.routeTimeouts[d.Type()] = route.Timeout
+		}
+		// every path of the downloader, including those of checksums, shares its rate limit
+		rateLimited := api.rateLimited(d.Type())
+		download := rateLimited(api.compressed(withMiddleware(route, authorisedBy(route, http.HandlerFunc(api.handleDownload(d))))))
+		checksum := rateLimited(withMiddleware(route, authorisedBy(route, http.HandlerFunc(api.handleChecksum(d)))))
+
+		// checksum paths are routed first, as the templates of download paths may also match them
+		path := api.pathPrefix + "/" + d.Type()
+		paths := append([]string{path}, prefixAll(path, route.Paths)...)
+		for _, p := range paths {
+			checksumPath := p + checksumSuffix
+			api.router.Path(checksumPath).Methods(http.MethodGet).Handler(checksum)
+			log.Info(ctx, "handling GET method on path "+checksumPath, log.Data{"query_parameters": d.QueryParameters()})
+		}
+		for _, p := range paths {
+			api.router.Path(p).Methods(route.Methods...).Handler(download)
+			log.Info(ctx, "handling "+strings.Join(route.Methods, " and ")+" methods on path "+p, log.Data{"query_parameters": d.QueryParameters()})
+		}
+	}
+}
+
+func prefixAll(prefix string, paths []string) []string {
+	prefixed := make([]string, len(paths))
+	for i, p := range paths {
+		prefixed[i] = prefix + "/" + strings.TrimPrefix(p, "/")
+	}
+	return prefixed
+}
+
+// rateLimited returns a function wrapping the handlers of the downloader type with its rate limit, if there is a rate
+// limiter
+func (api *DownloaderAPI) rateLimited(downloaderType string) func(http.Handler) http.Handler {
+	if api.rateLimiter == nil {
+		return func(handler http.Handler) http.Handler { return handler }
+	}
+	return api.rateLimiter.Middleware(downloaderType)
+}
+
+// compressed wraps the handler with the compressor, if there is one
+func (api *DownloaderAPI) compressed(handler http.Handler) http.Handler {
+	if api.compressor == nil {
+		return handler
+	}
+	return api.compressor.Handler(handler)
+}
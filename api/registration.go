@@ -0,0 +1,164 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-healthcheck/healthcheck"
+	"github.com/gorilla/mux"
+)
+
+// Route describes how requests for the downloads of a Downloader are routed. The zero value routes GET and HEAD
+// requests for {prefix}/{type} to the Downloader, with the api's default deadline and no authorisation or middleware.
+type Route struct {
+	// Methods are the methods routed to the Downloader. Defaults to GET and HEAD.
+	Methods []string
+	// Paths are templates of additional paths beneath {prefix}/{type} routed to the Downloader, such as "/{uri:.+}".
+	// The checksums of their downloads are served at the same paths with the suffix .sha256.
+	Paths []string
+	// Resolve converts the variables of a request on one of Paths into the parameters of the equivalent request on
+	// {prefix}/{type}, e.g. to select the format by a file extension, so that both are downloaded and cached alike.
+	// If it is nil, the variables are added to the parameters as they are. Requests it returns an error for are
+	// rejected with a 404.
+	Resolve func(vars map[string]string) (url.Values, error)
+	// Canonical returns the canonical path of a download beneath {prefix}/{type} and the parameters remaining in its
+	// query, which are advertised in a Link header with rel=canonical, or false if the download has no canonical path
+	Canonical func(params url.Values) (path string, query url.Values, ok bool)
+	// Authorise is called before each download, if set. Requests it returns an error for are rejected with a 401.
+	Authorise func(r *http.Request) error
+	// Timeout is the deadline for rendering a download, unless the api's configuration sets one for the type.
+	// The api's default deadline applies if it is zero.
+	Timeout time.Duration
+	// Middleware wraps the Downloader's handlers, the first being outermost. It runs after rate limiting and before
+	// authorisation.
+	Middleware []mux.MiddlewareFunc
+}
+
+// Routed is implemented by Downloaders that declare how their downloads are routed. Downloaders that don't implement
+// it are routed as described by the zero Route.
+type Routed interface {
+	Route() Route
+}
+
+// PathRouted is implemented by Downloaders that route path-style downloads without depending on this package, such as
+// LegacyDownloaders. They are routed as described by the Route made of the paths and functions they declare, unless
+// they also implement Routed.
+type PathRouted interface {
+	// Paths are the templates of the paths, as for Route.Paths
+	Paths() []string
+	// ResolvePath converts the variables of a request on one of Paths into parameters, as for Route.Resolve
+	ResolvePath(vars map[string]string) (url.Values, error)
+	// CanonicalPath returns the canonical path of a download and its remaining parameters, as for Route.Canonical
+	CanonicalPath(params url.Values) (path string, query url.Values, ok bool)
+}
+
+// declaredRoute returns the route declared by d as Routed or PathRouted, or the zero Route if it declares neither
+func declaredRoute(d any) Route {
+	switch r := d.(type) {
+	case Routed:
+		return r.Route()
+	case PathRouted:
+		return Route{Paths: r.Paths(), Resolve: r.ResolvePath, Canonical: r.CanonicalPath}
+	}
+	return Route{}
+}
+
+// HealthChecked is implemented by Downloaders that depend on other services. Their checkers are added to the
+// service's health check, keyed by the name of the check.
+type HealthChecked interface {
+	HealthChecks() map[string]healthcheck.Checker
+}
+
+// WithRoute returns the Downloader routed as described by route, for declaring the route of a Downloader that doesn't
+// implement Routed itself, or overriding the route it does
+func WithRoute(d Downloader, route Route) Downloader {
+	return &routedDownloader{Downloader: d, route: route}
+}
+
+type routedDownloader struct {
+	Downloader
+	route Route
+}
+
+func (d *routedDownloader) Route() Route {
+	return d.route
+}
+
+// HealthChecks passes on the checkers of the wrapped Downloader
+func (d *routedDownloader) HealthChecks() map[string]healthcheck.Checker {
+	if hc, ok := d.Downloader.(HealthChecked); ok {
+		return hc.HealthChecks()
+	}
+	return nil
+}
+
+// routeOf returns the route declared by the Downloader, with defaults applied
+func routeOf(d Downloader) Route {
+	route := declaredRoute(d)
+	if len(route.Methods) == 0 {
+		route.Methods = []string{http.MethodGet, http.MethodHead}
+	}
+	return route
+}
+
+// AddHealthChecks adds the checkers of each Downloader that declares them to the health check. NewDownloaderAPI adds
+// them itself; services that Mount downloaders should add them to their own health check.
+func AddHealthChecks(hc *healthcheck.HealthCheck, downloaders ...Downloader) error {
+	for _, d := range downloaders {
+		checked, ok := d.(HealthChecked)
+		if !ok {
+			continue
+		}
+		checks := checked.HealthChecks()
+		names := make([]string, 0, len(checks))
+		for name := range checks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := hc.AddCheck(name, checks[name]); err != nil {
+				return fmt.Errorf("failed to add %s health checker for %s downloads: %w", name, d.Type(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// authorisedBy rejects requests that the route's Authorise function returns an error for, if it has one
+func authorisedBy(route Route, handler http.Handler) http.Handler {
+	if route.Authorise == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := route.Authorise(r); err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// withMiddleware wraps the handler with the route's middleware, the first being outermost
+func withMiddleware(route Route, handler http.Handler) http.Handler {
+	for i := len(route.Middleware) - 1; i >= 0; i-- {
+		handler = route.Middleware[i](handler)
+	}
+	return handler
+}
+
+// canonicalLink returns the Link header advertising the canonical URL of a download, or an empty string if it has none
+func (api *DownloaderAPI) canonicalLink(d Downloader, route Route, params url.Values) string {
+	if route.Canonical == nil {
+		return ""
+	}
+	path, query, ok := route.Canonical(params)
+	if !ok {
+		return ""
+	}
+	canonical := url.URL{Path: api.pathPrefix + "/" + d.Type() + "/" + strings.TrimPrefix(path, "/"), RawQuery: query.Encode()}
+	return "<" + canonical.String() + `>; rel="canonical"`
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+
+	"github.com/ONSdigital/log.go/v2/log"
+)
+
+// handleVars writes the runtime metrics published with expvar, along with the load on the download limits of this api
+func (api *DownloaderAPI) handleVars(w http.ResponseWriter, r *http.Request) {
+	vars := make(map[string]json.RawMessage)
+	expvar.Do(func(kv expvar.KeyValue) {
+		vars[kv.Key] = json.RawMessage(kv.Value.String())
+	})
+	if api.limits != nil {
+		stats, err := json.Marshal(api.limits.Stats())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		vars["download_limits"] = stats
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(vars); err != nil {
+		log.Error(r.Context(), "handleVars: Error writing response", err)
+	}
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Key identifies a cached download
+type Key struct {
+	// Type is the type of the Downloader that produced the download
+	Type string `json:"type"`
+	// URI is the normalised uri of the downloaded content
+	URI string `json:"uri"`
+	// Variant distinguishes downloads of the same uri, e.g. by format and language
+	Variant string `json:"variant"`
+}
+
+// NewKey returns the key for a download of the uri. Equivalent uris, e.g. with and without a .json extension, produce the same key.
+func NewKey(downloaderType, uri, variant string) Key {
+	return Key{Type: downloaderType, URI: normaliseURI(uri), Variant: variant}
+}
+
+// String returns a representation of the key that is unique to it
+func (k Key) String() string {
+	return k.Type + "|" + k.URI + "|" + k.Variant
+}
+
+// Entry is a cached download
+type Entry struct {
+	Status int               `json:"status"`
+	Header map[string]string `json:"header"`
+	// Body is the content of an entry held in memory. Entries read from disk are streamed from their file instead, so
+	// the content of an entry returned by a Cache is read with Reader.
+	Body    []byte    `json:"-"`
+	Created time.Time `json:"created"`
+
+	// file is the open body file of an entry read from disk, which is fileSize bytes long
+	file     *os.File
+	fileSize int64
+}
+
+// Size returns the length of the content of the entry
+func (e *Entry) Size() int64 {
+	if e.file != nil {
+		return e.fileSize
+	}
+	return int64(len(e.Body))
+}
+
+// Reader returns a reader of the content of the entry, independent of any other reader of it
+func (e *Entry) Reader() io.ReadSeeker {
+	if e.file != nil {
+		return io.NewSectionReader(e.file, 0, e.fileSize)
+	}
+	return bytes.NewReader(e.Body)
+}
+
+// Close releases the file of an entry read from disk. Entries returned by a Cache are closed once they have been read.
+func (e *Entry) Close() error {
+	if e.file != nil {
+		return e.file.Close()
+	}
+	return nil
+}
+
+// size returns the approximate memory used by the entry
+func (e *Entry) size() int64 {
+	size := e.Size()
+	for key, value := range e.Header {
+		size += int64(len(key) + len(value))
+	}
+	return size
+}
+
+// Cache stores rendered downloads so that they don't need to be rendered again
+type Cache interface {
+	// Get returns the entry stored for the key, if there is one and it hasn't expired. The caller closes the entry.
+	Get(ctx context.Context, key Key) (*Entry, bool)
+	// Set stores the entry for the key, replacing any existing entry
+	Set(ctx context.Context, key Key, entry *Entry) error
+	// Invalidate removes the entries for every type, format and language of the uri, or of every uri starting with it if prefix is true.
+	// It returns the number of entries removed.
+	Invalidate(ctx context.Context, uri string, prefix bool) (int, error)
+}
+
+// matcher returns a function that reports whether a key is invalidated by the uri. A prefix matches whole path
+// segments, so /economy matches /economy and /economy/inflation but not /economyfoo.
+func matcher(uri string, prefix bool) func(Key) bool {
+	if !prefix {
+		normalised := normaliseURI(uri)
+		return func(k Key) bool { return k.URI == normalised }
+	}
+	normalised := strings.TrimSuffix(normalisePrefix(uri), "/")
+	return func(k Key) bool { return k.URI == normalised || strings.HasPrefix(k.URI, normalised+"/") }
+}
@@ -0,0 +1,338 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/log.go/v2/log"
+)
+
+const (
+	bodyExtension = ".body"
+	metaExtension = ".meta"
+	tempPrefix    = ".tmp-"
+)
+
+// errChecksumMismatch is returned when a cached body doesn't match the checksum recorded when it was written
+var errChecksumMismatch = errors.New("cached body does not match its checksum")
+
+// Disk is a Cache that stores downloads as files in a directory, so that they survive restarts.
+// Each entry is stored as a body file and a metadata file, named by the hash of its key. Both are written to temporary
+// files and renamed into place, the metadata last, so an entry is only visible once it is complete. Bodies are streamed
+// from their files, rather than read into memory.
+type Disk struct {
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[Key]*list.Element
+}
+
+// diskMeta is the content of the metadata file of an entry. The index holds the metadata of every entry, so that only
+// the body needs to be read from disk.
+type diskMeta struct {
+	Key      Key    `json:"key"`
+	Entry    *Entry `json:"entry"`
+	BodySize int64  `json:"body_size"`
+	SHA256   string `json:"sha256"`
+}
+
+var _ Cache = &Disk{}
+
+// NewDisk returns a Cache storing up to maxBytes of download bodies in the directory, each for up to ttl (or indefinitely
+// if ttl is zero). The directory is created if it doesn't exist, and any downloads already stored in it are indexed.
+func NewDisk(ctx context.Context, dir string, maxBytes int64, ttl time.Duration) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	d := &Disk{
+		dir:      dir,
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		now:      time.Now,
+		lru:      list.New(),
+		entries:  make(map[Key]*list.Element),
+	}
+	if err := d.index(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Get returns the entry stored for the key, if there is one, it hasn't expired and its body matches its checksum
+func (d *Disk) Get(ctx context.Context, key Key) (*Entry, bool) {
+	d.mu.Lock()
+	element, ok := d.entries[key]
+	if !ok {
+		d.mu.Unlock()
+		return nil, false
+	}
+	meta := element.Value.(*diskMeta)
+	if d.ttl > 0 && d.now().Sub(meta.Entry.Created) > d.ttl {
+		d.remove(ctx, element)
+		d.mu.Unlock()
+		return nil, false
+	}
+	d.lru.MoveToFront(element)
+	d.mu.Unlock()
+
+	file, err := d.openBody(meta)
+	if err != nil {
+		log.Warn(ctx, "removing invalid disk cache entry", log.Data{"key": key.String(), "error": err.Error()})
+		d.mu.Lock()
+		// the entry may have been replaced while its body was being read
+		if element, ok = d.entries[key]; ok && element.Value.(*diskMeta).SHA256 == meta.SHA256 {
+			d.remove(ctx, element)
+		}
+		d.mu.Unlock()
+		return nil, false
+	}
+
+	// the body is streamed from the open file, which remains readable if the entry is replaced or removed
+	entry := *meta.Entry
+	entry.file = file
+	entry.fileSize = meta.BodySize
+	return &entry, true
+}
+
+// Set writes the entry for the key to disk, evicting the least recently used entries to make space.
+// Entries larger than the cache are not stored. The files of the entry are written and synced before the lock is
+// taken, so that other entries can be read meanwhile, and only renamed into place while it is held.
+func (d *Disk) Set(ctx context.Context, key Key, entry *Entry) error {
+	bodySize := entry.Size()
+	if bodySize > d.maxBytes {
+		return nil
+	}
+	stored := &Entry{Status: entry.Status, Header: entry.Header, Created: entry.Created}
+	if stored.Created.IsZero() {
+		stored.Created = d.now()
+	}
+
+	bodyTemp, checksum, err := d.writeTemp(entry.Reader())
+	if err != nil {
+		return err
+	}
+	meta := &diskMeta{Key: key, Entry: stored, BodySize: bodySize, SHA256: hex.EncodeToString(checksum)}
+	b, err := json.Marshal(meta)
+	if err != nil {
+		os.Remove(bodyTemp)
+		return err
+	}
+	metaTemp, _, err := d.writeTemp(bytes.NewReader(b))
+	if err != nil {
+		os.Remove(bodyTemp)
+		return err
+	}
+
+	name := fileName(key)
+	d.mu.Lock()
+	if err = os.Rename(bodyTemp, filepath.Join(d.dir, name+bodyExtension)); err != nil {
+		d.mu.Unlock()
+		os.Remove(bodyTemp)
+		os.Remove(metaTemp)
+		return err
+	}
+	if err = os.Rename(metaTemp, filepath.Join(d.dir, name+metaExtension)); err != nil {
+		// the body of any entry already stored for the key has been replaced
+		if element, ok := d.entries[key]; ok {
+			d.remove(ctx, element)
+		} else {
+			d.removeFile(ctx, name+bodyExtension)
+		}
+		d.mu.Unlock()
+		os.Remove(metaTemp)
+		return err
+	}
+
+	if element, ok := d.entries[key]; ok {
+		d.size -= element.Value.(*diskMeta).BodySize
+		d.lru.Remove(element)
+	}
+	d.entries[key] = d.lru.PushFront(meta)
+	d.size += bodySize
+
+	for d.size > d.maxBytes {
+		d.remove(ctx, d.lru.Back())
+	}
+	d.mu.Unlock()
+
+	// the renames are only durable once the directory is synced
+	return syncDir(d.dir)
+}
+
+// Invalidate removes the entries for every type, format and language of the uri, or of every uri starting with it if prefix is true
+func (d *Disk) Invalidate(ctx context.Context, uri string, prefix bool) (int, error) {
+	matches := matcher(uri, prefix)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	removed := 0
+	for key, element := range d.entries {
+		if matches(key) {
+			d.remove(ctx, element)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// index rebuilds the index of the entries in the directory, removing incomplete entries and unfinished writes
+func (d *Disk) index(ctx context.Context) error {
+	files, err := os.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+
+	var metas []*diskMeta
+	for _, f := range files {
+		name := f.Name()
+		switch {
+		case strings.HasPrefix(name, tempPrefix):
+			d.removeFile(ctx, name)
+		case strings.HasSuffix(name, metaExtension):
+			meta, err := d.readMeta(name)
+			if err != nil {
+				log.Warn(ctx, "removing invalid disk cache entry", log.Data{"file": name, "error": err.Error()})
+				d.removeFile(ctx, name)
+				d.removeFile(ctx, strings.TrimSuffix(name, metaExtension)+bodyExtension)
+				continue
+			}
+			metas = append(metas, meta)
+		case strings.HasSuffix(name, bodyExtension):
+			if _, err := os.Stat(filepath.Join(d.dir, strings.TrimSuffix(name, bodyExtension)+metaExtension)); err != nil {
+				d.removeFile(ctx, name)
+			}
+		}
+	}
+
+	// the most recently created entries are treated as the most recently used
+	sort.Slice(metas, func(i, j int) bool { return metas[i].Entry.Created.Before(metas[j].Entry.Created) })
+	for _, meta := range metas {
+		d.entries[meta.Key] = d.lru.PushFront(meta)
+		d.size += meta.BodySize
+	}
+	for d.size > d.maxBytes {
+		d.remove(ctx, d.lru.Back())
+	}
+
+	log.Info(ctx, "indexed disk cache", log.Data{"dir": d.dir, "entries": d.lru.Len(), "size": d.size})
+	return nil
+}
+
+// readMeta reads a metadata file, checking that its body file is complete
+func (d *Disk) readMeta(name string) (*diskMeta, error) {
+	b, err := os.ReadFile(filepath.Join(d.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	var meta diskMeta
+	if err = json.Unmarshal(b, &meta); err != nil {
+		return nil, err
+	}
+	if meta.Entry == nil || fileName(meta.Key)+metaExtension != name {
+		return nil, errors.New("metadata does not match its file name")
+	}
+	info, err := os.Stat(filepath.Join(d.dir, fileName(meta.Key)+bodyExtension))
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != meta.BodySize {
+		return nil, fmt.Errorf("body is %d bytes, expected %d", info.Size(), meta.BodySize)
+	}
+	return &meta, nil
+}
+
+// openBody opens the body file of the entry, verifying its length and checksum without reading it into memory
+func (d *Disk) openBody(meta *diskMeta) (*os.File, error) {
+	f, err := os.Open(filepath.Join(d.dir, fileName(meta.Key)+bodyExtension))
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	n, err := io.Copy(hash, f)
+	if err == nil && (n != meta.BodySize || hex.EncodeToString(hash.Sum(nil)) != meta.SHA256) {
+		err = errChecksumMismatch
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// writeTemp writes the content to a temporary file in the directory and syncs it, returning its name and the checksum
+// of the content. The file is renamed into place once complete, so that entries are written atomically.
+func (d *Disk) writeTemp(content io.Reader) (string, []byte, error) {
+	f, err := os.CreateTemp(d.dir, tempPrefix+"*")
+	if err != nil {
+		return "", nil, err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), content)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+	return f.Name(), hash.Sum(nil), nil
+}
+
+// syncDir syncs the directory, so that the files renamed into it survive a crash
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// remove deletes the entry from the index and the disk. The caller must hold the lock.
+func (d *Disk) remove(ctx context.Context, element *list.Element) {
+	meta := d.lru.Remove(element).(*diskMeta)
+	delete(d.entries, meta.Key)
+	d.size -= meta.BodySize
+
+	name := fileName(meta.Key)
+	d.removeFile(ctx, name+metaExtension)
+	d.removeFile(ctx, name+bodyExtension)
+}
+
+// removeFile deletes a file from the directory, logging any failure
+func (d *Disk) removeFile(ctx context.Context, name string) {
+	if err := os.Remove(filepath.Join(d.dir, name)); err != nil && !os.IsNotExist(err) {
+		log.Error(ctx, "failed to remove disk cache file", err, log.Data{"file": name})
+	}
+}
+
+// fileName returns the name of the files storing the entry for the key, without an extension
+func fileName(key Key) string {
+	hash := sha256.Sum256([]byte(key.String()))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDisk(t *testing.T) {
+	t.Parallel()
+	Convey("Given a disk cache containing a download", t, func() {
+		dir := t.TempDir()
+		c, err := NewDisk(ctx, dir, 1024, time.Hour)
+		So(err, ShouldBeNil)
+
+		key := NewKey("table", "/economy/table.json", "format=xlsx|en")
+		header := map[string]string{"Content-Type": "text/csv"}
+		So(c.Set(ctx, key, &Entry{Status: 200, Header: header, Body: []byte("a,b\n1,2\n")}), ShouldBeNil)
+
+		Convey("When it is retrieved", func() {
+			entry, ok := c.Get(ctx, key)
+
+			Convey("The body and headers should be returned, with the body streamed from its file", func() {
+				So(ok, ShouldBeTrue)
+				So(entry.Status, ShouldEqual, 200)
+				So(entry.Header, ShouldResemble, header)
+				So(entry.Body, ShouldBeNil)
+				So(entry.Size(), ShouldEqual, 8)
+				So(readEntry(entry), ShouldEqual, "a,b\n1,2\n")
+			})
+
+			Convey("And it is invalidated before it has been read", func() {
+				_, err := c.Invalidate(ctx, "/economy/table", false)
+				So(err, ShouldBeNil)
+
+				Convey("The body should still be readable", func() {
+					So(readEntry(entry), ShouldEqual, "a,b\n1,2\n")
+				})
+			})
+		})
+
+		Convey("When the cache is reopened", func() {
+			reopened, err := NewDisk(ctx, dir, 1024, time.Hour)
+			So(err, ShouldBeNil)
+
+			Convey("The download should still be cached", func() {
+				entry, ok := reopened.Get(ctx, key)
+				So(ok, ShouldBeTrue)
+				So(readEntry(entry), ShouldEqual, "a,b\n1,2\n")
+			})
+		})
+
+		Convey("When the body is corrupted on disk", func() {
+			So(os.WriteFile(filepath.Join(dir, fileName(key)+bodyExtension), []byte("a,b\n9,9\n"), 0o600), ShouldBeNil)
+			_, ok := c.Get(ctx, key)
+
+			Convey("It should not be returned, and should be removed", func() {
+				So(ok, ShouldBeFalse)
+				_, err := os.Stat(filepath.Join(dir, fileName(key)+metaExtension))
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the directory contains an unfinished write and a body without metadata", func() {
+			So(os.WriteFile(filepath.Join(dir, tempPrefix+"123"), []byte("partial"), 0o600), ShouldBeNil)
+			So(os.WriteFile(filepath.Join(dir, "orphan"+bodyExtension), []byte("orphan"), 0o600), ShouldBeNil)
+			_, err := NewDisk(ctx, dir, 1024, time.Hour)
+			So(err, ShouldBeNil)
+
+			Convey("They should be removed when the cache is reopened", func() {
+				files, err := os.ReadDir(dir)
+				So(err, ShouldBeNil)
+				So(files, ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("When the uri is invalidated", func() {
+			n, err := c.Invalidate(ctx, "economy/table", false)
+
+			Convey("The download should be removed from disk", func() {
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 1)
+				files, err := os.ReadDir(dir)
+				So(err, ShouldBeNil)
+				So(files, ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given a full disk cache", t, func() {
+		dir := t.TempDir()
+		c, err := NewDisk(ctx, dir, 10, time.Hour)
+		So(err, ShouldBeNil)
+		first, second := NewKey("t", "/a", ""), NewKey("t", "/b", "")
+		So(c.Set(ctx, first, &Entry{Body: []byte("aaaaa")}), ShouldBeNil)
+		So(c.Set(ctx, second, &Entry{Body: []byte("bbbbb")}), ShouldBeNil)
+
+		Convey("When another download is stored", func() {
+			third := NewKey("t", "/c", "")
+			So(c.Set(ctx, third, &Entry{Body: []byte("ccccc")}), ShouldBeNil)
+
+			Convey("The least recently used download should be evicted from disk", func() {
+				_, ok := c.Get(ctx, first)
+				So(ok, ShouldBeFalse)
+				_, ok = c.Get(ctx, third)
+				So(ok, ShouldBeTrue)
+				files, err := os.ReadDir(dir)
+				So(err, ShouldBeNil)
+				So(files, ShouldHaveLength, 4)
+			})
+		})
+	})
+
+	Convey("Given a memory cache limited to small entries layered over a disk cache", t, func() {
+		disk, err := NewDisk(ctx, t.TempDir(), 1024, time.Hour)
+		So(err, ShouldBeNil)
+		memory := NewMemory(1024, time.Hour, WithMaxEntryBytes(4))
+		c := NewTiered(memory, disk)
+
+		Convey("When a large download is stored", func() {
+			key := NewKey("table", "/economy/table", "format=xlsx|en")
+			So(c.Set(ctx, key, &Entry{Status: 200, Body: []byte("large body")}), ShouldBeNil)
+
+			Convey("It should only be cached on disk", func() {
+				_, ok := memory.Get(ctx, key)
+				So(ok, ShouldBeFalse)
+				entry, ok := c.Get(ctx, key)
+				So(ok, ShouldBeTrue)
+				So(readEntry(entry), ShouldEqual, "large body")
+			})
+		})
+	})
+}
+
+func TestTiered(t *testing.T) {
+	t.Parallel()
+	Convey("Given a memory cache layered over a disk cache containing a download", t, func() {
+		disk, err := NewDisk(ctx, t.TempDir(), 1024, time.Hour)
+		So(err, ShouldBeNil)
+		memory := NewMemory(1024, time.Hour)
+		c := NewTiered(memory, disk)
+
+		key := NewKey("table", "/economy/table", "format=csv|en")
+		So(disk.Set(ctx, key, &Entry{Status: 200, Body: []byte("body")}), ShouldBeNil)
+
+		Convey("When it is retrieved", func() {
+			entry, ok := c.Get(ctx, key)
+
+			Convey("It should be returned from disk and copied into memory", func() {
+				So(ok, ShouldBeTrue)
+				So(readEntry(entry), ShouldEqual, "body")
+				_, ok = memory.Get(ctx, key)
+				So(ok, ShouldBeTrue)
+			})
+
+			Convey("And the uri is invalidated", func() {
+				n, err := c.Invalidate(ctx, "/economy/table", false)
+
+				Convey("It should be removed from both tiers", func() {
+					So(err, ShouldBeNil)
+					So(n, ShouldEqual, 1)
+					_, ok = c.Get(ctx, key)
+					So(ok, ShouldBeFalse)
+				})
+			})
+		})
+	})
+
+	Convey("Given a memory cache limited to small entries layered over a disk cache", t, func() {
+		disk, err := NewDisk(ctx, t.TempDir(), 1024, time.Hour)
+		So(err, ShouldBeNil)
+		memory := NewMemory(1024, time.Hour, WithMaxEntryBytes(4))
+		c := NewTiered(memory, disk)
+
+		Convey("When a large download is stored", func() {
+			key := NewKey("table", "/economy/table", "format=xlsx|en")
+			So(c.Set(ctx, key, &Entry{Status: 200, Body: []byte("large body")}), ShouldBeNil)
+
+			Convey("It should only be cached on disk", func() {
+				_, ok := memory.Get(ctx, key)
+				So(ok, ShouldBeFalse)
+				entry, ok := c.Get(ctx, key)
+				So(ok, ShouldBeTrue)
+				So(readEntry(entry), ShouldEqual, "large body")
+			})
+		})
+	})
+}
+
+// readEntry reads the content of the entry and closes it
+func readEntry(entry *Entry) string {
+	defer entry.Close()
+	b, err := io.ReadAll(entry.Reader())
+	So(err, ShouldBeNil)
+	return string(b)
+}
@@ -0,0 +1,24 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/ONSdigital/dp-file-downloader/event"
+	"github.com/ONSdigital/log.go/v2/log"
+)
+
+// InvalidateOnPublish returns an event handler that removes the cached downloads of every published uri
+func InvalidateOnPublish(c Cache) event.Handler {
+	return func(ctx context.Context, e *event.ContentPublished) error {
+		total := 0
+		for _, uri := range e.URIs {
+			n, err := c.Invalidate(ctx, uri, false)
+			if err != nil {
+				return err
+			}
+			total += n
+		}
+		log.Info(ctx, "invalidated cached downloads of published content", log.Data{"collection_id": e.CollectionID, "invalidated": total})
+		return nil
+	}
+}
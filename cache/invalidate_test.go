@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-file-downloader/event"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInvalidateOnPublish(t *testing.T) {
+	t.Parallel()
+	Convey("Given a cache consuming content published events", t, func() {
+		c := NewMemory(1024, time.Hour)
+		So(c.Set(ctx, NewKey("table", "/a/table", "format=csv|en"), &Entry{Body: []byte("a")}), ShouldBeNil)
+		So(c.Set(ctx, NewKey("table", "/b/table", "format=csv|en"), &Entry{Body: []byte("b")}), ShouldBeNil)
+
+		consumer := event.NewChannelConsumer(1)
+		So(consumer.Publish(ctx, &event.ContentPublished{CollectionID: "c", URIs: []string{"/a/table.json"}}), ShouldBeNil)
+		So(consumer.Close(ctx), ShouldBeNil)
+
+		Convey("When the event is consumed", func() {
+			event.Consume(ctx, consumer, InvalidateOnPublish(c))
+
+			Convey("The cached downloads of the published uris should be removed", func() {
+				_, ok := c.Get(ctx, NewKey("table", "/a/table", "format=csv|en"))
+				So(ok, ShouldBeFalse)
+				_, ok = c.Get(ctx, NewKey("table", "/b/table", "format=csv|en"))
+				So(ok, ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Memory is an in-memory Cache that evicts the least recently used entries once it exceeds its maximum size
+type Memory struct {
+	maxBytes      int64
+	maxEntryBytes int64
+	ttl           time.Duration
+	now           func() time.Time
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[Key]*list.Element
+}
+
+type memoryItem struct {
+	key   Key
+	entry *Entry
+}
+
+var _ Cache = &Memory{}
+
+// MemoryOption configures optional behaviour of a Memory cache
+type MemoryOption func(*Memory)
+
+// WithMaxEntryBytes limits the size of the entries stored in memory, e.g. so that large files are only cached on disk
+func WithMaxEntryBytes(maxEntryBytes int64) MemoryOption {
+	return func(m *Memory) {
+		m.maxEntryBytes = maxEntryBytes
+	}
+}
+
+// NewMemory returns an in-memory Cache holding up to maxBytes of downloads, each for up to ttl (or indefinitely if ttl is zero)
+func NewMemory(maxBytes int64, ttl time.Duration, opts ...MemoryOption) *Memory {
+	m := &Memory{
+		maxBytes:      maxBytes,
+		maxEntryBytes: maxBytes,
+		ttl:           ttl,
+		now:           time.Now,
+		lru:           list.New(),
+		entries:       make(map[Key]*list.Element),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Get returns the entry stored for the key, if there is one and it hasn't expired
+func (m *Memory) Get(ctx context.Context, key Key) (*Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	element, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	item := element.Value.(*memoryItem)
+	if m.ttl > 0 && m.now().Sub(item.entry.Created) > m.ttl {
+		m.remove(element)
+		return nil, false
+	}
+	m.lru.MoveToFront(element)
+	return item.entry, true
+}
+
+// Set stores the entry for the key, evicting the least recently used entries to make space.
+// Entries larger than the cache, or than the maximum entry size, are not stored. The content of an entry read from
+// disk is read into memory.
+func (m *Memory) Set(ctx context.Context, key Key, entry *Entry) error {
+	if entry.size() > m.maxBytes || entry.size() > m.maxEntryBytes {
+		return nil
+	}
+	if entry.file != nil {
+		body, err := io.ReadAll(entry.Reader())
+		if err != nil {
+			return err
+		}
+		entry = &Entry{Status: entry.Status, Header: entry.Header, Body: body, Created: entry.Created}
+	}
+	if entry.Created.IsZero() {
+		entry.Created = m.now()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if element, ok := m.entries[key]; ok {
+		m.remove(element)
+	}
+	m.entries[key] = m.lru.PushFront(&memoryItem{key: key, entry: entry})
+	m.size += entry.size()
+
+	for m.size > m.maxBytes {
+		m.remove(m.lru.Back())
+	}
+	return nil
+}
+
+// Invalidate removes the entries for every type, format and language of the uri, or of every uri starting with it if prefix is true
+func (m *Memory) Invalidate(ctx context.Context, uri string, prefix bool) (int, error) {
+	matches := matcher(uri, prefix)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	for key, element := range m.entries {
+		if matches(key) {
+			m.remove(element)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// remove deletes the element from the cache. The caller must hold the lock.
+func (m *Memory) remove(element *list.Element) {
+	item := m.lru.Remove(element).(*memoryItem)
+	delete(m.entries, item.key)
+	m.size -= item.entry.size()
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var ctx = context.Background()
+
+func TestMemory(t *testing.T) {
+	t.Parallel()
+	Convey("Given an in-memory cache with downloads of several formats and languages of a table", t, func() {
+		c := NewMemory(1024, time.Hour)
+		for _, key := range []Key{
+			NewKey("table", "/economy/inflation/table.json", "format=csv|en"),
+			NewKey("table", "/economy/inflation/table", "format=xlsx|cy"),
+			NewKey("table", "/economy/inflation/other.json", "format=csv|en"),
+			NewKey("table", "/people/table.json", "format=csv|en"),
+			NewKey("table", "/economyfoo/table.json", "format=csv|en"),
+		} {
+			So(c.Set(ctx, key, &Entry{Status: 200, Body: []byte("body")}), ShouldBeNil)
+		}
+
+		Convey("When a download is retrieved by an equivalent uri", func() {
+			entry, ok := c.Get(ctx, NewKey("table", "economy/inflation/table/", "format=csv|en"))
+
+			Convey("The cached entry should be returned", func() {
+				So(ok, ShouldBeTrue)
+				So(readEntry(entry), ShouldEqual, "body")
+			})
+		})
+
+		Convey("When a uri is invalidated", func() {
+			n, err := c.Invalidate(ctx, "/economy/inflation/table.json", false)
+
+			Convey("Every variant of that uri should be removed", func() {
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 2)
+				_, ok := c.Get(ctx, NewKey("table", "/economy/inflation/table", "format=xlsx|cy"))
+				So(ok, ShouldBeFalse)
+				_, ok = c.Get(ctx, NewKey("table", "/economy/inflation/other", "format=csv|en"))
+				So(ok, ShouldBeTrue)
+			})
+		})
+
+		Convey("When a prefix is invalidated", func() {
+			n, err := c.Invalidate(ctx, "/economy/", true)
+
+			Convey("Every uri under the prefix should be removed", func() {
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 3)
+				_, ok := c.Get(ctx, NewKey("table", "/people/table", "format=csv|en"))
+				So(ok, ShouldBeTrue)
+			})
+		})
+
+		Convey("When a prefix without a trailing slash is invalidated", func() {
+			n, err := c.Invalidate(ctx, "/economy", true)
+
+			Convey("Only uris beneath that path segment should be removed", func() {
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 3)
+				_, ok := c.Get(ctx, NewKey("table", "/economyfoo/table", "format=csv|en"))
+				So(ok, ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given an in-memory cache that is full", t, func() {
+		c := NewMemory(10, time.Hour)
+		first, second, third := NewKey("t", "/a", ""), NewKey("t", "/b", ""), NewKey("t", "/c", "")
+		So(c.Set(ctx, first, &Entry{Body: []byte("aaaa")}), ShouldBeNil)
+		So(c.Set(ctx, second, &Entry{Body: []byte("bbbb")}), ShouldBeNil)
+		c.Get(ctx, first)
+
+		Convey("When another download is stored", func() {
+			So(c.Set(ctx, third, &Entry{Body: []byte("cccc")}), ShouldBeNil)
+
+			Convey("The least recently used download should be evicted", func() {
+				_, ok := c.Get(ctx, second)
+				So(ok, ShouldBeFalse)
+				_, ok = c.Get(ctx, first)
+				So(ok, ShouldBeTrue)
+				_, ok = c.Get(ctx, third)
+				So(ok, ShouldBeTrue)
+			})
+		})
+
+		Convey("When a download larger than the cache is stored", func() {
+			So(c.Set(ctx, third, &Entry{Body: []byte("too large to cache")}), ShouldBeNil)
+
+			Convey("It should not be cached, and nothing evicted", func() {
+				_, ok := c.Get(ctx, third)
+				So(ok, ShouldBeFalse)
+				_, ok = c.Get(ctx, second)
+				So(ok, ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given an in-memory cache containing an expired download", t, func() {
+		c := NewMemory(1024, time.Minute)
+		key := NewKey("t", "/a", "")
+		So(c.Set(ctx, key, &Entry{Body: []byte("a"), Created: time.Now().Add(-2 * time.Minute)}), ShouldBeNil)
+
+		Convey("When it is retrieved", func() {
+			_, ok := c.Get(ctx, key)
+
+			Convey("It should not be returned", func() {
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
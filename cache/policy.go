@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy decides the caching headers for downloads, with TTLs configurable per downloader type
+type Policy struct {
+	// MaxAge is how long browsers may cache published downloads, unless overridden for the downloader type by MaxAgeByType
+	MaxAge       time.Duration
+	MaxAgeByType map[string]time.Duration
+	// SurrogateMaxAge is how long the CDN may cache published downloads, unless overridden for the downloader type by SurrogateMaxAgeByType
+	SurrogateMaxAge       time.Duration
+	SurrogateMaxAgeByType map[string]time.Duration
+}
+
+// Headers returns the Cache-Control, Surrogate-Control and Surrogate-Key headers for a successful download. Published
+// downloads are stored by shared caches under their host and url, which must therefore select their language.
+// Private downloads (previews and collection content) must never be stored by a shared cache.
+func (p *Policy) Headers(downloaderType, uri string, private bool) map[string]string {
+	if private {
+		return map[string]string{
+			"Cache-Control":     "private, no-store",
+			"Surrogate-Control": "no-store",
+		}
+	}
+
+	headers := map[string]string{
+		"Cache-Control":     cacheControl("public", ttl(p.MaxAge, p.MaxAgeByType, downloaderType)),
+		"Surrogate-Control": cacheControl("", ttl(p.SurrogateMaxAge, p.SurrogateMaxAgeByType, downloaderType)),
+	}
+	if keys := SurrogateKeys(downloaderType, uri); len(keys) > 0 {
+		headers["Surrogate-Key"] = strings.Join(keys, " ")
+	}
+	return headers
+}
+
+// ErrorHeaders returns the caching headers for a failed download, which must not be cached
+func (p *Policy) ErrorHeaders() map[string]string {
+	return map[string]string{
+		"Cache-Control":     "no-store",
+		"Surrogate-Control": "no-store",
+	}
+}
+
+// SurrogateKeys returns the keys that a download of the given uri is tagged with in the CDN.
+// Every format and language of the same uri shares a key, so that they can be purged together.
+func SurrogateKeys(downloaderType, uri string) []string {
+	keys := []string{downloaderType}
+	if uri == "" {
+		return keys
+	}
+	return append(keys, downloaderType+":"+normaliseURI(uri))
+}
+
+// normaliseURI returns the uri without a trailing .json extension or slash, escaped so that it contains no spaces
+func normaliseURI(uri string) string {
+	return normalisePrefix(strings.TrimSuffix(strings.TrimSuffix(uri, "/"), ".json"))
+}
+
+// normalisePrefix returns the uri with a leading slash, escaped so that it contains no spaces
+func normalisePrefix(uri string) string {
+	if !strings.HasPrefix(uri, "/") {
+		uri = "/" + uri
+	}
+	segments := strings.Split(uri, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// ttl returns the TTL configured for the downloader type, falling back to the default
+func ttl(defaultTTL time.Duration, byType map[string]time.Duration, downloaderType string) time.Duration {
+	if t, ok := byType[downloaderType]; ok {
+		return t
+	}
+	return defaultTTL
+}
+
+// cacheControl returns a cache directive with the given max-age, or no-cache if it is not positive
+func cacheControl(visibility string, maxAge time.Duration) string {
+	directive := "no-cache"
+	if maxAge > 0 {
+		directive = "max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	}
+	if visibility == "" {
+		return directive
+	}
+	return visibility + ", " + directive
+}
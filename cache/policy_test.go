@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPolicyHeaders(t *testing.T) {
+	t.Parallel()
+	Convey("Given a cache policy with a TTL overridden for tables", t, func() {
+		policy := &Policy{
+			MaxAge:                time.Minute,
+			MaxAgeByType:          map[string]time.Duration{"table": 10 * time.Minute},
+			SurrogateMaxAge:       time.Hour,
+			SurrogateMaxAgeByType: map[string]time.Duration{"other": 0},
+		}
+
+		Convey("When headers are requested for a published table", func() {
+			headers := policy.Headers("table", "/economy/inflation/my table.json", false)
+
+			Convey("The table TTLs and surrogate keys derived from the uri should be returned", func() {
+				So(headers["Cache-Control"], ShouldEqual, "public, max-age=600")
+				So(headers["Surrogate-Control"], ShouldEqual, "max-age=3600")
+				So(headers["Surrogate-Key"], ShouldEqual, "table table:/economy/inflation/my%20table")
+				So(headers, ShouldNotContainKey, "Vary")
+			})
+		})
+
+		Convey("When headers are requested for a downloader type with a zero TTL", func() {
+			headers := policy.Headers("other", "/foo", false)
+
+			Convey("The default browser TTL should be used and the CDN told to revalidate", func() {
+				So(headers["Cache-Control"], ShouldEqual, "public, max-age=60")
+				So(headers["Surrogate-Control"], ShouldEqual, "no-cache")
+			})
+		})
+
+		Convey("When headers are requested for private content", func() {
+			headers := policy.Headers("table", "/economy/inflation/bar.json", true)
+
+			Convey("The content should not be stored by any cache", func() {
+				So(headers["Cache-Control"], ShouldEqual, "private, no-store")
+				So(headers["Surrogate-Control"], ShouldEqual, "no-store")
+				So(headers, ShouldNotContainKey, "Surrogate-Key")
+			})
+		})
+	})
+}
+
+func TestSurrogateKeys(t *testing.T) {
+	t.Parallel()
+	Convey("Surrogate keys should be the same for equivalent uris", t, func() {
+		So(SurrogateKeys("table", "/foo/bar.json"), ShouldResemble, []string{"table", "table:/foo/bar"})
+		So(SurrogateKeys("table", "foo/bar"), ShouldResemble, []string{"table", "table:/foo/bar"})
+		So(SurrogateKeys("table", ""), ShouldResemble, []string{"table"})
+	})
+}
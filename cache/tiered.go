@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/ONSdigital/log.go/v2/log"
+)
+
+// Tiered is a Cache layering faster, smaller caches over slower, larger ones, e.g. memory over disk
+type Tiered struct {
+	tiers []Cache
+}
+
+var _ Cache = &Tiered{}
+
+// NewTiered returns a Cache that uses each of the tiers in turn, fastest first
+func NewTiered(tiers ...Cache) *Tiered {
+	return &Tiered{tiers: tiers}
+}
+
+// Get returns the entry from the fastest tier that has it, copying it into the faster tiers
+func (t *Tiered) Get(ctx context.Context, key Key) (*Entry, bool) {
+	for i, tier := range t.tiers {
+		entry, ok := tier.Get(ctx, key)
+		if !ok {
+			continue
+		}
+		for _, faster := range t.tiers[:i] {
+			if err := faster.Set(ctx, key, entry); err != nil {
+				log.Error(ctx, "failed to promote cache entry", err, log.Data{"key": key.String()})
+			}
+		}
+		return entry, true
+	}
+	return nil, false
+}
+
+// Set stores the entry in every tier, returning the first error
+func (t *Tiered) Set(ctx context.Context, key Key, entry *Entry) error {
+	var firstErr error
+	for _, tier := range t.tiers {
+		if err := tier.Set(ctx, key, entry); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Invalidate removes the entries from every tier, returning the largest number removed from any one tier
+func (t *Tiered) Invalidate(ctx context.Context, uri string, prefix bool) (int, error) {
+	removed := 0
+	for _, tier := range t.tiers {
+		n, err := tier.Invalidate(ctx, uri, prefix)
+		if err != nil {
+			return removed, err
+		}
+		if n > removed {
+			removed = n
+		}
+	}
+	return removed, nil
+}
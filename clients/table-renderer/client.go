@@ -62,10 +62,10 @@ func (c *Client) PostBody(ctx context.Context, format string, body []byte) (resp
 
 func (c *Client) post(ctx context.Context, uri string, body []byte) (*http.Response, error) {
 	r := bytes.NewReader(body)
-	req, err := http.NewRequest(http.MethodPost, uri, r)
-	req.Header.Set("Content-Type", "application/json")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, r)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/json")
 	return c.cli.Do(ctx, req)
 }
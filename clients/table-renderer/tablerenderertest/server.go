@@ -0,0 +1,176 @@
+// Package tablerenderertest provides an in-process fake table-renderer service, for testing the downloader end to end
+// without the real service.
+package tablerenderertest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// contentTypes are the Content-Types the table-renderer responds with for each format it renders
+var contentTypes = map[string]string{
+	"html": "text/html; charset=utf-8",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"csv":  "text/csv; charset=utf-8",
+}
+
+// Response scripts how the server responds to a request
+type Response struct {
+	// Status is the status code of the response. Defaults to 200.
+	Status int
+	// Body is the body of the response. If nil, the json definition posted to be rendered is echoed back.
+	Body []byte
+	// ContentType is the Content-Type of the response. Defaults to that of the format rendered.
+	ContentType string
+	// Latency delays the response, unless the request is cancelled first
+	Latency time.Duration
+	// Truncated cuts the body short and closes the connection, after declaring the full Content-Length
+	Truncated bool
+}
+
+// Request is a request the server received to render a table
+type Request struct {
+	Format      string
+	ContentType string
+	Body        []byte
+}
+
+// Server is a fake table-renderer service serving /render/{format} and /health
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses map[string]Response
+	queue     []Response
+	health    Response
+	requests  []Request
+}
+
+// NewServer starts a Server, which renders every format by echoing back the json definition until scripted otherwise.
+// The caller should Close it when finished.
+func NewServer() *Server {
+	s := &Server{responses: make(map[string]Response)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /render/{format}", s.handleRender)
+	mux.HandleFunc("GET /health", s.handleHealth)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Respond scripts the response to every request to render the format, or to render any format without a response
+// of its own if format is empty
+func (s *Server) Respond(format string, resp Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[format] = resp
+}
+
+// Enqueue scripts the responses to the next requests to render any format, in order. Once they have been used,
+// requests are responded to as scripted with Respond.
+func (s *Server) Enqueue(resps ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, resps...)
+}
+
+// RespondHealth scripts the response to requests for the health of the service
+func (s *Server) RespondHealth(resp Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.health = resp
+}
+
+// Requests returns the requests to render a table received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Reset removes all scripted responses and received requests
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses = make(map[string]Response)
+	s.queue = nil
+	s.health = Response{}
+	s.requests = nil
+}
+
+func (s *Server) handleRender(w http.ResponseWriter, r *http.Request) {
+	format := r.PathValue("format")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Format: format, ContentType: r.Header.Get("Content-Type"), Body: body})
+	resp, ok := s.responses[format]
+	if !ok {
+		resp = s.responses[""]
+	}
+	if len(s.queue) > 0 {
+		resp, s.queue = s.queue[0], s.queue[1:]
+	}
+	s.mu.Unlock()
+
+	if resp.Body == nil {
+		resp.Body = body
+	}
+	if resp.ContentType == "" {
+		resp.ContentType = contentTypes[format]
+	}
+	write(w, r, resp)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	resp := s.health
+	s.mu.Unlock()
+
+	if resp.Body == nil {
+		resp.Body = []byte(`{"status":"OK"}`)
+	}
+	if resp.ContentType == "" {
+		resp.ContentType = "application/json"
+	}
+	write(w, r, resp)
+}
+
+// write waits for the latency of the response, then writes it
+func write(w http.ResponseWriter, r *http.Request, resp Response) {
+	if resp.Latency > 0 {
+		select {
+		case <-time.After(resp.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", resp.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp.Body)))
+	w.WriteHeader(status)
+
+	body := resp.Body
+	if resp.Truncated {
+		// writing less than the declared Content-Length makes the server close the connection
+		body = body[:len(body)/2]
+	}
+	_, _ = w.Write(body)
+}
+
+// Malformed returns a successful response with a body that is not a valid file in any format
+func Malformed() Response {
+	return Response{Body: []byte(strings.Repeat("PK\x03\x04 not a zip file ", 4))}
+}
@@ -0,0 +1,93 @@
+package zebedeefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ONSdigital/dp-api-clients-go/v2/zebedee"
+	health "github.com/ONSdigital/dp-healthcheck/healthcheck"
+	"github.com/ONSdigital/dp-net/v3/request"
+)
+
+const (
+	masterDir      = "master"
+	collectionsDir = "collections"
+)
+
+// collectionStages are the subdirectories of a collection holding its content, in the order Zebedee reads them:
+// the most recent edits first.
+var collectionStages = []string{"inprogress", "complete", "reviewed"}
+
+// Client serves content from a local directory laid out like Zebedee's, for running the service without Zebedee.
+// Published content is read from master/, and the content of a collection from collections/<id>/, where it overlays
+// the published content. Collection content may be staged in inprogress/, complete/ and reviewed/ subdirectories,
+// as in Zebedee, or directly in the collection directory.
+type Client struct {
+	dir string
+}
+
+// New creates a new instance of Client serving content from the given directory
+func New(dir string) *Client {
+	return &Client{dir: dir}
+}
+
+// GetResourceBody returns the contents of the file at uri, from the collection if one is given and it contains the
+// file, otherwise from the published content. As in Zebedee, content in a language other than English is read from
+// the file with the language as a suffix of its name (e.g. data_cy.json for data.json), from the collection or the
+// published content, falling back to the file itself if there is no translation in either. A zebedee.ErrInvalidZebedeeResponse with a 404 code is returned if the file does
+// not exist.
+func (c *Client) GetResourceBody(ctx context.Context, userAccessToken, collectionID, lang, uri string) ([]byte, error) {
+	// cleaning the uri as an absolute path prevents it from escaping the content directory
+	name := filepath.FromSlash(path.Clean("/" + uri))
+	names := []string{name}
+	if lang != "" && lang != request.DefaultLang {
+		ext := filepath.Ext(name)
+		names = []string{strings.TrimSuffix(name, ext) + "_" + filepath.Base(lang) + ext, name}
+	}
+
+	var dirs []string
+	if collectionID != "" {
+		collection := filepath.Join(c.dir, collectionsDir, filepath.Base(collectionID))
+		for _, stage := range collectionStages {
+			dirs = append(dirs, filepath.Join(collection, stage))
+		}
+		dirs = append(dirs, collection)
+	}
+	dirs = append(dirs, filepath.Join(c.dir, masterDir))
+
+	for _, name := range names {
+		for _, dir := range dirs {
+			file := filepath.Join(dir, name)
+			b, err := os.ReadFile(file)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				// a directory is not a resource, so is not found
+				if info, statErr := os.Stat(file); statErr == nil && info.IsDir() {
+					continue
+				}
+				return nil, err
+			}
+			return b, nil
+		}
+	}
+	return nil, zebedee.ErrInvalidZebedeeResponse{ActualCode: http.StatusNotFound, URI: uri}
+}
+
+// Checker reports the client as healthy if the published content directory can be read
+func (c *Client) Checker(ctx context.Context, state *health.CheckState) error {
+	dir := filepath.Join(c.dir, masterDir)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		msg := fmt.Sprintf("content directory %s cannot be read", dir)
+		return state.Update(health.StatusCritical, msg, 0)
+	}
+	return state.Update(health.StatusOK, "content directory is readable", 0)
+}
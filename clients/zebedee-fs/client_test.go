@@ -0,0 +1,120 @@
+package zebedeefs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ONSdigital/dp-api-clients-go/v2/zebedee"
+	health "github.com/ONSdigital/dp-healthcheck/healthcheck"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var ctx = context.Background()
+
+func writeFile(t *testing.T, name, content string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetResourceBody(t *testing.T) {
+	Convey("Given a content directory with published content and a collection overlaying it", t, func() {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "master", "economy", "table.json"), "published")
+		writeFile(t, filepath.Join(dir, "master", "economy", "other.json"), "published other")
+		writeFile(t, filepath.Join(dir, "collections", "mycollection", "inprogress", "economy", "table.json"), "in progress")
+		writeFile(t, filepath.Join(dir, "collections", "mycollection", "reviewed", "economy", "reviewed.json"), "reviewed")
+		writeFile(t, filepath.Join(dir, "secret.json"), "secret")
+		client := New(dir)
+
+		Convey("When published content is requested", func() {
+			b, err := client.GetResourceBody(ctx, "", "", "en", "/economy/table.json")
+
+			Convey("Then the published file should be returned", func() {
+				So(err, ShouldBeNil)
+				So(string(b), ShouldEqual, "published")
+			})
+		})
+
+		Convey("When content is requested from the collection", func() {
+			Convey("Then the collection's version of a file should be returned", func() {
+				b, err := client.GetResourceBody(ctx, "", "mycollection", "en", "/economy/table.json")
+				So(err, ShouldBeNil)
+				So(string(b), ShouldEqual, "in progress")
+
+				b, err = client.GetResourceBody(ctx, "", "mycollection", "en", "/economy/reviewed.json")
+				So(err, ShouldBeNil)
+				So(string(b), ShouldEqual, "reviewed")
+			})
+
+			Convey("And published files not in the collection should be returned", func() {
+				b, err := client.GetResourceBody(ctx, "", "mycollection", "en", "/economy/other.json")
+				So(err, ShouldBeNil)
+				So(string(b), ShouldEqual, "published other")
+			})
+		})
+
+		Convey("When content is requested in Welsh", func() {
+			writeFile(t, filepath.Join(dir, "master", "economy", "table_cy.json"), "published cy")
+
+			Convey("Then the Welsh version of a file should be returned", func() {
+				b, err := client.GetResourceBody(ctx, "", "", "cy", "/economy/table.json")
+				So(err, ShouldBeNil)
+				So(string(b), ShouldEqual, "published cy")
+			})
+
+			Convey("And the file itself should be returned if it has no Welsh version", func() {
+				b, err := client.GetResourceBody(ctx, "", "", "cy", "/economy/other.json")
+				So(err, ShouldBeNil)
+				So(string(b), ShouldEqual, "published other")
+			})
+
+			Convey("And the published translation should be preferred to a collection's English version", func() {
+				b, err := client.GetResourceBody(ctx, "", "mycollection", "cy", "/economy/table.json")
+				So(err, ShouldBeNil)
+				So(string(b), ShouldEqual, "published cy")
+			})
+		})
+
+		Convey("When content that does not exist is requested", func() {
+			for _, uri := range []string{"/economy/missing.json", "/economy", "/../secret.json"} {
+				_, err := client.GetResourceBody(ctx, "", "", "en", uri)
+
+				Convey("Then a 404 zebedee response error should be returned for "+uri, func() {
+					var e zebedee.ErrInvalidZebedeeResponse
+					So(errors.As(err, &e), ShouldBeTrue)
+					So(e.ActualCode, ShouldEqual, http.StatusNotFound)
+				})
+			}
+		})
+
+		Convey("When the health of the client is checked", func() {
+			state := health.NewCheckState("content")
+			So(client.Checker(ctx, state), ShouldBeNil)
+
+			Convey("Then it should be healthy", func() {
+				So(state.Status(), ShouldEqual, health.StatusOK)
+			})
+		})
+	})
+
+	Convey("Given a content directory without published content", t, func() {
+		client := New(t.TempDir())
+
+		Convey("When the health of the client is checked", func() {
+			state := health.NewCheckState("content")
+			So(client.Checker(ctx, state), ShouldBeNil)
+
+			Convey("Then it should be critical", func() {
+				So(state.Status(), ShouldEqual, health.StatusCritical)
+			})
+		})
+	})
+}
@@ -0,0 +1,253 @@
+// Command dp-file-downloader-cli renders table definitions from local json files, using the same pipeline as the
+// table downloads of dp-file-downloader, so that tables can be checked before they are published.
+//
+// Usage:
+//
+//	dp-file-downloader-cli [flags] <file or directory>...
+//
+// Each json file, or every json file beneath each directory, is rendered in each of the requested formats and written
+// to the output directory. With -check, the definitions are validated and any problems reported instead.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ONSdigital/dp-api-clients-go/v2/zebedee"
+	tableRenderer "github.com/ONSdigital/dp-file-downloader/clients/table-renderer"
+	"github.com/ONSdigital/dp-file-downloader/table"
+	"github.com/ONSdigital/dp-net/v3/request"
+	"github.com/ONSdigital/log.go/v2/log"
+)
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command, returning 0 on success, 1 if any table could not be rendered or has problems, and 2 if the
+// command was used incorrectly
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("dp-file-downloader-cli", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	formats := flags.String("format", "html", "comma separated formats to render, e.g. xlsx,csv")
+	outDir := flags.String("out", ".", "directory the rendered files are written to")
+	rendererURL := flags.String("renderer-url", "", "url of a table renderer service, for formats that cannot be rendered in-process such as xlsx")
+	lang := flags.String("lang", request.DefaultLang, "language to render the tables in")
+	siteURL := flags.String("site-url", "", "public url of the website, used to link back to the source of a table")
+	check := flags.Bool("check", false, "validate the table definitions and report any problems, without rendering them")
+	verbose := flags.Bool("verbose", false, "write the logs of the rendering pipeline to stderr")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: dp-file-downloader-cli [flags] <file or directory>...\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	if *verbose {
+		log.SetDestination(stderr, stderr)
+	} else {
+		log.SetDestination(io.Discard, io.Discard)
+	}
+
+	files, err := findDefinitions(flags.Args())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if *check {
+		return checkDefinitions(files, stdout)
+	}
+
+	var renderer table.RendererClient = table.LocalRenderer{}
+	if *rendererURL != "" {
+		renderer = tableRenderer.New(*rendererURL)
+	}
+	downloader := table.NewDownloader(files, renderer, table.WithSiteURL(*siteURL))
+
+	failed := false
+	for _, uri := range files.uris() {
+		for _, format := range strings.Split(*formats, ",") {
+			output, err := render(ctx, &downloader, files, uri, strings.TrimSpace(format), *lang, *outDir)
+			if err != nil {
+				fmt.Fprintf(stderr, "%s: %s: %v\n", files[uri], format, err)
+				failed = true
+				continue
+			}
+			fmt.Fprintln(stdout, output)
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// definitionFiles is a table.ZebedeeClient serving table definitions from local files, keyed by the uri they are requested with
+type definitionFiles map[string]string
+
+var _ table.ZebedeeClient = definitionFiles{}
+
+// GetResourceBody returns the contents of the file for the uri
+func (files definitionFiles) GetResourceBody(ctx context.Context, userAccessToken, collectionID, lang, uri string) ([]byte, error) {
+	file, ok := files[uri]
+	if !ok {
+		return nil, zebedee.ErrInvalidZebedeeResponse{ActualCode: http.StatusNotFound, URI: uri}
+	}
+	return os.ReadFile(file)
+}
+
+// uris returns the uris of the files in alphabetical order
+func (files definitionFiles) uris() []string {
+	uris := make([]string, 0, len(files))
+	for uri := range files {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	return uris
+}
+
+// input returns the definition file that is the same file as file, if there is one
+func (files definitionFiles) input(file string) (string, bool) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", false
+	}
+	info, statErr := os.Stat(file)
+	for _, definition := range files {
+		if other, err := filepath.Abs(definition); err == nil && other == abs {
+			return definition, true
+		}
+		if statErr != nil {
+			continue
+		}
+		if otherInfo, err := os.Stat(definition); err == nil && os.SameFile(info, otherInfo) {
+			return definition, true
+		}
+	}
+	return "", false
+}
+
+// findDefinitions returns the json files given as arguments, or found beneath the directories given as arguments.
+// Each is keyed by a uri made from its path relative to the argument it was found with. An error is returned if two
+// files have the same uri, as they would be rendered to the same output files.
+func findDefinitions(args []string) (definitionFiles, error) {
+	files := make(definitionFiles)
+	add := func(uri, file string) error {
+		if other, ok := files[uri]; ok && filepath.Clean(other) != filepath.Clean(file) {
+			return fmt.Errorf("%s and %s would both be rendered as %s; render them separately", other, file, uri)
+		}
+		files[uri] = file
+		return nil
+	}
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if err := add("/"+filepath.Base(arg), arg); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		err = filepath.WalkDir(arg, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || filepath.Ext(file) != ".json" {
+				return err
+			}
+			rel, err := filepath.Rel(arg, file)
+			if err != nil {
+				return err
+			}
+			return add("/"+filepath.ToSlash(rel), file)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no table definitions found")
+	}
+	return files, nil
+}
+
+// checkDefinitions reports the ways in which each definition does not conform to its schema, returning 1 if there are any
+func checkDefinitions(files definitionFiles, stdout io.Writer) int {
+	status := 0
+	for _, uri := range files.uris() {
+		definition, err := os.ReadFile(files[uri])
+		if err != nil {
+			fmt.Fprintf(stdout, "%s: %v\n", files[uri], err)
+			status = 1
+			continue
+		}
+		var validationErr *table.ValidationError
+		if err := table.Validate(definition); errors.As(err, &validationErr) {
+			for _, violation := range validationErr.Violations {
+				fmt.Fprintf(stdout, "%s: %s\n", files[uri], violation)
+			}
+			status = 1
+		}
+	}
+	return status
+}
+
+// render downloads the table at uri in the format through the downloader, writing it to the output directory beneath
+// the directory of the uri. The path of the file written is returned. An error is returned, and nothing written, if the
+// file would overwrite one of the definition files being rendered.
+func render(ctx context.Context, downloader *table.Downloader, files definitionFiles, uri, format, lang, outDir string) (string, error) {
+	query := url.Values{"format": {format}, "uri": {uri}}
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/download/table?"+query.Encode(), http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	r.AddCookie(&http.Cookie{Name: request.LocaleCookieKey, Value: lang})
+
+	body, headers, status, err := downloader.Download(r)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	if status != http.StatusOK {
+		return "", fmt.Errorf("rendering failed with status %d", status)
+	}
+
+	_, params, err := mime.ParseMediaType(headers["Content-Disposition"])
+	if err != nil || params["filename"] == "" {
+		return "", fmt.Errorf("no filename in content disposition %q", headers["Content-Disposition"])
+	}
+	output := filepath.Join(outDir, filepath.FromSlash(path.Dir(uri)), params["filename"])
+	if definition, ok := files.input(output); ok {
+		return "", fmt.Errorf("%s would overwrite the table definition %s; choose another -out directory", output, definition)
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return "", err
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		return "", err
+	}
+	return output, file.Close()
+}
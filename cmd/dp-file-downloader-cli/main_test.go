@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var tableDefinition = `{
+	"filename": "mytable",
+	"title": "Population",
+	"data": [["Area", "2020"], ["England", "1,100"], ["Wales", "310"]],
+	"row_formats": [{"row": 0, "heading": true}]
+}`
+
+func writeDefinition(t *testing.T, file, definition string) {
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(definition), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	Convey("Given a directory of table definitions", t, func() {
+		dir := t.TempDir()
+		writeDefinition(t, filepath.Join(dir, "tables", "economy", "mytable.json"), tableDefinition)
+		var stdout, stderr bytes.Buffer
+
+		Convey("When the tables are rendered to another directory", func() {
+			out := filepath.Join(dir, "rendered")
+			status := run(context.Background(), []string{"-format", "html,csv", "-out", out, filepath.Join(dir, "tables")}, &stdout, &stderr)
+
+			Convey("Each format is written beneath the output directory at the path of its definition", func() {
+				So(status, ShouldEqual, 0)
+				So(stderr.String(), ShouldBeEmpty)
+				html, err := os.ReadFile(filepath.Join(out, "economy", "mytable.html"))
+				So(err, ShouldBeNil)
+				So(string(html), ShouldContainSubstring, "Population")
+				csv, err := os.ReadFile(filepath.Join(out, "economy", "mytable.csv"))
+				So(err, ShouldBeNil)
+				So(string(csv), ShouldContainSubstring, "England,\"1,100\"")
+				So(stdout.String(), ShouldContainSubstring, filepath.Join(out, "economy", "mytable.html"))
+			})
+		})
+
+		Convey("When a table is rendered as json into the directory of its definition", func() {
+			file := filepath.Join(dir, "tables", "economy", "mytable.json")
+			status := run(context.Background(), []string{"-format", "json", "-out", filepath.Dir(file), file}, &stdout, &stderr)
+
+			Convey("The definition is not overwritten and the clash is reported", func() {
+				So(status, ShouldEqual, 1)
+				So(stderr.String(), ShouldContainSubstring, "would overwrite the table definition")
+				definition, err := os.ReadFile(file)
+				So(err, ShouldBeNil)
+				So(string(definition), ShouldEqual, tableDefinition)
+			})
+		})
+
+		Convey("When another file with the same name is rendered alongside it", func() {
+			other := filepath.Join(dir, "other", "mytable.json")
+			writeDefinition(t, other, tableDefinition)
+			out := filepath.Join(dir, "rendered")
+			status := run(context.Background(), []string{"-out", out, filepath.Join(dir, "tables", "economy", "mytable.json"), other}, &stdout, &stderr)
+
+			Convey("Both would be rendered to the same path, so nothing is rendered", func() {
+				So(status, ShouldEqual, 1)
+				So(stderr.String(), ShouldContainSubstring, "would both be rendered as /mytable.json")
+				_, err := os.Stat(out)
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestCheck(t *testing.T) {
+	Convey("Given a valid and an invalid table definition", t, func() {
+		dir := t.TempDir()
+		writeDefinition(t, filepath.Join(dir, "valid.json"), tableDefinition)
+		var stdout, stderr bytes.Buffer
+
+		Convey("When only the valid definition is checked", func() {
+			status := run(context.Background(), []string{"-check", filepath.Join(dir, "valid.json")}, &stdout, &stderr)
+
+			Convey("No problems are reported", func() {
+				So(status, ShouldEqual, 0)
+				So(stdout.String(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When both are checked", func() {
+			writeDefinition(t, filepath.Join(dir, "invalid.json"), `{"title": "No data"}`)
+			status := run(context.Background(), []string{"-check", dir}, &stdout, &stderr)
+
+			Convey("Each violation of the invalid definition is reported, and nothing is rendered", func() {
+				So(status, ShouldEqual, 1)
+				So(stdout.String(), ShouldContainSubstring, filepath.Join(dir, "invalid.json")+": filename: is required")
+				So(stdout.String(), ShouldContainSubstring, filepath.Join(dir, "invalid.json")+": data: is required")
+				So(stdout.String(), ShouldNotContainSubstring, filepath.Join(dir, "valid.json")+":")
+				entries, err := os.ReadDir(dir)
+				So(err, ShouldBeNil)
+				So(entries, ShouldHaveLength, 2)
+			})
+		})
+	})
+}
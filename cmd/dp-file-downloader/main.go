@@ -11,7 +11,9 @@ import (
 	healthcheckclient "github.com/ONSdigital/dp-api-clients-go/v2/health"
 	"github.com/ONSdigital/dp-api-clients-go/v2/zebedee"
 	"github.com/ONSdigital/dp-file-downloader/api"
+	"github.com/ONSdigital/dp-file-downloader/cache"
 	tableRenderer "github.com/ONSdigital/dp-file-downloader/clients/table-renderer"
+	zebedeeFS "github.com/ONSdigital/dp-file-downloader/clients/zebedee-fs"
 	"github.com/ONSdigital/dp-file-downloader/config"
 	"github.com/ONSdigital/dp-file-downloader/table"
 	health "github.com/ONSdigital/dp-healthcheck/healthcheck"
@@ -53,27 +55,46 @@ func main() {
 		log.Error(ctx, "failed to create service version information", err)
 	}
 
-	apiRouterCli := healthcheckclient.NewClient("api-router", cfg.APIRouterURL)
-
-	zc := zebedee.NewWithHealthClient(apiRouterCli)
 	tabrend := tableRenderer.New(cfg.TableRendererHost)
 
-	healthcheck := health.New(versionInfo, cfg.HealthCheckCriticalTimeout, cfg.HealthCheckInterval)
-
-	if err = registerCheckers(ctx, &healthcheck, tabrend, apiRouterCli); err != nil {
-		os.Exit(1)
+	var zc table.ZebedeeClient
+	tableOpts := []table.Option{table.WithSiteURL(cfg.SiteURL)}
+	if cfg.LocalContentDir != "" {
+		zc = zebedeeFS.New(cfg.LocalContentDir)
+		tableOpts = append(tableOpts, table.WithContentCheckName("local content"))
+		log.Info(ctx, "serving content from local directory", log.Data{"dir": cfg.LocalContentDir})
+	} else {
+		zc = zebedee.NewWithHealthClient(healthcheckclient.NewClient("api-router", cfg.APIRouterURL))
 	}
 
-	healthcheck.Start(ctx)
+	healthcheck := health.New(versionInfo, cfg.HealthCheckCriticalTimeout, cfg.HealthCheckInterval)
 
 	apiErrors := make(chan error, 1)
 
-	tableDownloader := table.NewDownloader(zc, tabrend)
+	// the api adds the health checks of the services each downloader depends on
+	tableDownloader := table.NewDownloader(zc, tabrend, tableOpts...)
 
-	api.StartDownloaderAPI(ctx, cfg, apiErrors, &healthcheck, &tableDownloader)
+	downloadCache, err := newDownloadCache(ctx, cfg)
+	if err != nil {
+		log.Fatal(ctx, "unable to create download cache", err)
+		os.Exit(1)
+	}
+
+	downloaderAPI, err := api.NewDownloaderAPI(ctx, cfg, &healthcheck, downloadCache, api.Adapt(&tableDownloader))
+	if err != nil {
+		log.Fatal(ctx, "unable to create downloader api", err)
+		os.Exit(1)
+	}
+
+	// checks can't be added once the health check has started, so it is started once the api has added them all
+	healthcheck.Start(ctx)
+	downloaderAPI.Start(ctx, apiErrors)
 
 	// Gracefully shutdown the application closing any open resources.
 	gracefulShutdown := func() {
+		// let in-flight downloads complete before the server is shut down
+		downloaderAPI.Drain(ctx)
+
 		log.Info(ctx, fmt.Sprintf("Shutdown with timeout: %s", cfg.ShutdownTimeout))
 		gracefulCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 
@@ -86,7 +107,7 @@ func main() {
 			log.Info(gracefulCtx, "stop health checkers")
 			healthcheck.Stop()
 
-			if err = api.Close(gracefulCtx); err != nil {
+			if err = downloaderAPI.Shutdown(gracefulCtx); err != nil {
 				log.Error(gracefulCtx, "error closing api", err)
 				hasShutdownErrs = true
 			}
@@ -122,22 +143,19 @@ func main() {
 	}
 }
 
-func registerCheckers(ctx context.Context, h *health.HealthCheck, r *tableRenderer.Client, apiRouterCli *healthcheckclient.Client) (err error) {
-	hasErrors := false
-
-	if err = h.AddCheck("frontend renderer", r.Checker); err != nil {
-		hasErrors = true
-		log.Error(ctx, "failed to add frontend renderer checker", err)
+// newDownloadCache returns the configured cache of rendered downloads: in memory, layered over disk if a directory is
+// configured, in which case only small downloads are held in memory. It returns nil if caching is disabled.
+func newDownloadCache(ctx context.Context, cfg *config.Config) (cache.Cache, error) {
+	if !cfg.CacheEnabled {
+		return nil, nil
 	}
-
-	if err = h.AddCheck("API router", apiRouterCli.Checker); err != nil {
-		hasErrors = true
-		log.Error(ctx, "failed to add API router health checker", err)
+	if cfg.CacheDiskDir == "" {
+		return cache.NewMemory(cfg.CacheMaxBytes, cfg.CacheTTL), nil
 	}
-
-	if hasErrors {
-		return errors.New("Error(s) registering checkers for healthcheck")
+	memory := cache.NewMemory(cfg.CacheMaxBytes, cfg.CacheTTL, cache.WithMaxEntryBytes(cfg.CacheMemoryMaxEntryBytes))
+	disk, err := cache.NewDisk(ctx, cfg.CacheDiskDir, cfg.CacheDiskMaxBytes, cfg.CacheTTL)
+	if err != nil {
+		return nil, err
 	}
-
-	return nil
+	return cache.NewTiered(memory, disk), nil
 }
@@ -0,0 +1,259 @@
+//go:build component
+
+package component
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-file-downloader/api"
+	"github.com/ONSdigital/dp-file-downloader/cache"
+	tableRenderer "github.com/ONSdigital/dp-file-downloader/clients/table-renderer"
+	"github.com/ONSdigital/dp-file-downloader/clients/table-renderer/tablerenderertest"
+	zebedeeFS "github.com/ONSdigital/dp-file-downloader/clients/zebedee-fs"
+	"github.com/ONSdigital/dp-file-downloader/config"
+	"github.com/ONSdigital/dp-file-downloader/table"
+	"github.com/ONSdigital/dp-healthcheck/healthcheck"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var ctx = context.Background()
+
+const tableURI = "/economy/mytable.json"
+
+// service is the downloader running end to end against the fixture content and a fake table-renderer
+type service struct {
+	*httptest.Server
+	renderer *tablerenderertest.Server
+}
+
+// newService starts the downloader with caching enabled and a short download deadline
+func newService(t *testing.T) *service {
+	defaults, err := config.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := *defaults
+	cfg.DownloadTimeout = 500 * time.Millisecond
+	cfg.DownloadTimeoutByType = nil
+
+	renderer := tablerenderertest.NewServer()
+	downloader := table.NewDownloader(zebedeeFS.New("testdata/content"), tableRenderer.New(renderer.URL))
+	hc := healthcheck.New(healthcheck.VersionInfo{}, time.Minute, time.Minute)
+	downloadCache := cache.NewMemory(cfg.CacheMaxBytes, cfg.CacheTTL)
+
+	downloaderAPI, err := api.NewDownloaderAPI(ctx, &cfg, &hc, downloadCache, api.Adapt(&downloader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &service{Server: httptest.NewServer(downloaderAPI.Handler()), renderer: renderer}
+	t.Cleanup(func() {
+		s.Close()
+		renderer.Close()
+	})
+	return s
+}
+
+// download requests the table in the format, returning the response and as much of its body as could be read
+func (s *service) download(format, collectionID string) (*http.Response, string, error) {
+	req, err := http.NewRequest("GET", s.URL+"/download/table?format="+format+"&uri="+tableURI, http.NoBody)
+	if err != nil {
+		return nil, "", err
+	}
+	if collectionID != "" {
+		req.Header.Set("Collection-Id", collectionID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp, string(body), err
+}
+
+func TestDownload(t *testing.T) {
+	Convey("Given the downloader and a table-renderer", t, func() {
+		s := newService(t)
+
+		Convey("When a published table is downloaded", func() {
+			resp, body, err := s.download("html", "")
+			So(err, ShouldBeNil)
+
+			Convey("Then the table should be rendered by the table-renderer", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Content-Type"), ShouldEqual, "text/html; charset=utf-8")
+				So(resp.Header.Get("Content-Disposition"), ShouldEqual, `attachment; filename="mytable.html"`)
+				So(body, ShouldContainSubstring, `"title": "Inflation by year"`)
+				So(resp.Header.Get("Content-Length"), ShouldEqual, strconv.Itoa(len(body)))
+				So(resp.Header.Get("Repr-Digest"), ShouldStartWith, "sha-256=:")
+
+				requests := s.renderer.Requests()
+				So(requests, ShouldHaveLength, 1)
+				So(requests[0].Format, ShouldEqual, "html")
+				So(requests[0].ContentType, ShouldEqual, "application/json")
+			})
+
+			Convey("And downloading it again should be served from the cache", func() {
+				resp, _, err := s.download("html", "")
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(s.renderer.Requests(), ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When a published table is downloaded at its path", func() {
+			resp, err := http.Get(s.URL + "/download/table/economy/mytable.html")
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+
+			Convey("Then it should be rendered, and its path advertised as canonical", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Content-Disposition"), ShouldEqual, `attachment; filename="mytable.html"`)
+				So(resp.Header.Get("Link"), ShouldEqual, `</download/table/economy/mytable.html>; rel="canonical"`)
+				So(s.renderer.Requests(), ShouldHaveLength, 1)
+			})
+
+			Convey("And downloading it with a query should be served from the cache", func() {
+				resp, _, err := s.download("html", "")
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Link"), ShouldEqual, `</download/table/economy/mytable.html>; rel="canonical"`)
+				So(s.renderer.Requests(), ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When a table in a collection is downloaded", func() {
+			resp, body, err := s.download("csv", "mycollection")
+			So(err, ShouldBeNil)
+
+			Convey("Then the collection's version should be rendered as provisional, and not cached", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(body, ShouldContainSubstring, table.ProvisionalBanner)
+				So(body, ShouldContainSubstring, `"9.1"`)
+				So(resp.Header.Get("Cache-Control"), ShouldContainSubstring, "no-store")
+			})
+		})
+
+		Convey("When a table that does not exist is downloaded", func() {
+			req, err := http.NewRequest("GET", s.URL+"/download/table?format=html&uri=/economy/missing.json", http.NoBody)
+			So(err, ShouldBeNil)
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+
+			Convey("Then a 404 should be returned without calling the table-renderer", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+				So(s.renderer.Requests(), ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestRendererFailures(t *testing.T) {
+	Convey("Given the downloader and a table-renderer", t, func() {
+		s := newService(t)
+
+		Convey("When the table-renderer fails once", func() {
+			s.renderer.Enqueue(tablerenderertest.Response{Status: http.StatusInternalServerError})
+			resp, _, err := s.download("html", "")
+			So(err, ShouldBeNil)
+
+			Convey("Then the render should be retried and succeed", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(s.renderer.Requests(), ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("When the table-renderer rejects the table", func() {
+			s.renderer.Respond("xlsx", tablerenderertest.Response{Status: http.StatusBadRequest, Body: []byte("invalid table")})
+			resp, body, err := s.download("xlsx", "")
+			So(err, ShouldBeNil)
+
+			Convey("Then its response should be passed on, and not cached", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+				So(body, ShouldEqual, "invalid table")
+
+				resp, _, err = s.download("xlsx", "")
+				So(err, ShouldBeNil)
+				So(s.renderer.Requests(), ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("When the table-renderer is slower than the download deadline", func() {
+			s.renderer.Respond("", tablerenderertest.Response{Latency: time.Minute})
+			resp, _, err := s.download("html", "")
+			So(err, ShouldBeNil)
+
+			Convey("Then a 504 should be returned", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusGatewayTimeout)
+			})
+		})
+
+		Convey("When the table-renderer returns a malformed xlsx file for a download with metadata", func() {
+			s.renderer.Respond("xlsx", tablerenderertest.Malformed())
+			resp, _, err := s.download("xlsx&metadata=true", "")
+			So(err, ShouldBeNil)
+
+			Convey("Then a 500 should be returned", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusInternalServerError)
+			})
+		})
+
+		Convey("When the table-renderer cuts its response short", func() {
+			s.renderer.Enqueue(tablerenderertest.Response{Truncated: true})
+			resp, _, err := s.download("html", "")
+			So(err, ShouldBeNil)
+
+			Convey("Then a 500 should be returned before any of the download is sent, and it should not be cached", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusInternalServerError)
+				So(resp.Header.Get("Content-Disposition"), ShouldBeEmpty)
+
+				resp, body, err := s.download("html", "")
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(body, ShouldContainSubstring, `"row_formats"`)
+				So(s.renderer.Requests(), ShouldHaveLength, 2)
+			})
+		})
+	})
+}
+
+func TestRendererHealth(t *testing.T) {
+	Convey("Given a table-renderer client", t, func() {
+		renderer := tablerenderertest.NewServer()
+		defer renderer.Close()
+		client := tableRenderer.New(renderer.URL)
+
+		Convey("When the table-renderer is healthy", func() {
+			state := healthcheck.NewCheckState("table-renderer")
+			So(client.Checker(ctx, state), ShouldBeNil)
+
+			Convey("Then the check should be OK", func() {
+				So(state.Status(), ShouldEqual, healthcheck.StatusOK)
+			})
+		})
+
+		for status, expected := range map[int]string{
+			http.StatusTooManyRequests:     healthcheck.StatusWarning,
+			http.StatusInternalServerError: healthcheck.StatusCritical,
+		} {
+			Convey("When the table-renderer reports its health with status "+http.StatusText(status), func() {
+				renderer.RespondHealth(tablerenderertest.Response{Status: status})
+				state := healthcheck.NewCheckState("table-renderer")
+				So(client.Checker(ctx, state), ShouldBeNil)
+
+				Convey("Then the check should be "+strings.ToLower(expected), func() {
+					So(state.Status(), ShouldEqual, expected)
+					So(state.StatusCode(), ShouldEqual, status)
+				})
+			})
+		}
+	})
+}
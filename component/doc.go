@@ -0,0 +1,3 @@
+// Package component holds the component tests of the service, which run the downloader end to end against fixture
+// content and an in-process fake table-renderer. They are built with the component tag: make test-component
+package component
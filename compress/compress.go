@@ -0,0 +1,162 @@
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	encodingGzip   = "gzip"
+	encodingBrotli = "br"
+)
+
+// Config configures which responses are compressed
+type Config struct {
+	// MinBytes is the size of the smallest response that is compressed, as compressing small responses gains little
+	MinBytes int
+	// Brotli enables the br encoding, which is preferred over gzip for clients that accept both
+	Brotli bool
+	// GzipLevel is the gzip compression level, from 1 (fastest) to 9 (smallest). Defaults to gzip.DefaultCompression if zero.
+	GzipLevel int
+}
+
+// Compressor compresses responses in the encoding negotiated with the client's Accept-Encoding header
+type Compressor struct {
+	cfg Config
+	// encodings are the supported encodings, in order of preference
+	encodings []string
+	gzipPool  sync.Pool
+}
+
+// New returns a Compressor, or an error if the gzip level is invalid
+func New(cfg Config) (*Compressor, error) {
+	if cfg.GzipLevel == 0 {
+		cfg.GzipLevel = gzip.DefaultCompression
+	}
+	if _, err := gzip.NewWriterLevel(io.Discard, cfg.GzipLevel); err != nil {
+		return nil, fmt.Errorf("invalid gzip level: %w", err)
+	}
+
+	c := &Compressor{cfg: cfg, encodings: []string{encodingGzip}}
+	if cfg.Brotli {
+		c.encodings = []string{encodingBrotli, encodingGzip}
+	}
+	c.gzipPool.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, cfg.GzipLevel)
+		return w
+	}
+	return c, nil
+}
+
+// Handler wraps a handler, compressing its successful responses of a compressible content type that are at least
+// the minimum size. Partial responses to range requests are never compressed. The ETags of compressed responses sent
+// in If-None-Match are passed to the handler as the ETags of the uncompressed responses, so that it can answer them.
+func (c *Compressor) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &responseWriter{ResponseWriter: w, compressor: c, request: r, encoding: c.negotiate(r.Header.Get("Accept-Encoding"))}
+		defer cw.close()
+		if cw.encoding != "" {
+			r, cw.decodedETags = decodeETags(r, cw.encoding)
+		}
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// decodeETags returns the request with the ETags of responses compressed in the encoding in its If-None-Match header
+// replaced by the ETags of the uncompressed responses, and whether there were any
+func decodeETags(r *http.Request, encoding string) (*http.Request, bool) {
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	suffix := "-" + encoding + `"`
+	if !strings.Contains(ifNoneMatch, suffix) {
+		return r, false
+	}
+	tags := strings.Split(ifNoneMatch, ",")
+	for i, tag := range tags {
+		tags[i] = strings.TrimSpace(tag)
+		if strings.HasSuffix(tags[i], suffix) {
+			tags[i] = strings.TrimSuffix(tags[i], suffix) + `"`
+		}
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("If-None-Match", strings.Join(tags, ", "))
+	return r, true
+}
+
+// encodedETag returns the ETag of a response compressed in the encoding, given that of the uncompressed response. A
+// strong ETag identifies the exact bytes of the response, so the compressed response needs one of its own.
+func encodedETag(etag, encoding string) string {
+	if !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// negotiate returns the supported encoding most preferred by the client, or an empty string if it accepts none
+func (c *Compressor) negotiate(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = q
+		}
+		qualities[strings.ToLower(strings.TrimSpace(coding))] = quality
+	}
+
+	best, bestQuality := "", 0.0
+	for _, encoding := range c.encodings {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// encoder returns a writer compressing to w in the encoding, and a function that returns it for reuse once closed
+func (c *Compressor) encoder(encoding string, w io.Writer) (io.WriteCloser, func()) {
+	if encoding == encodingBrotli {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression), func() {}
+	}
+	gz := c.gzipPool.Get().(*gzip.Writer)
+	gz.Reset(w)
+	return gz, func() { c.gzipPool.Put(gz) }
+}
+
+// isCompressible returns true if the content type is text, which compresses well. Formats such as xlsx, ods and zip
+// files are already compressed.
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript":
+		return true
+	}
+	return false
+}
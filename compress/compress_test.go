@@ -0,0 +1,218 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var text = strings.Repeat("Year,Inflation\n2020,0.9\n", 100)
+
+// textHandler responds with text of the content type, declaring its Content-Length if known
+func textHandler(contentType string, known bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("ETag", `"abc"`)
+		if known {
+			w.Header().Set("Content-Length", strconv.Itoa(len(text)))
+		}
+		_, _ = io.WriteString(w, text)
+	})
+}
+
+func serve(handler http.Handler, method string, header map[string]string) *http.Response {
+	r := httptest.NewRequest(method, "/download/table", http.NoBody)
+	for key, value := range header {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Result()
+}
+
+func TestNegotiate(t *testing.T) {
+	t.Parallel()
+	Convey("Given compressors with and without Brotli", t, func() {
+		gzipOnly, err := New(Config{})
+		So(err, ShouldBeNil)
+		withBrotli, err := New(Config{Brotli: true})
+		So(err, ShouldBeNil)
+
+		Convey("The most preferred supported encoding should be chosen", func() {
+			So(gzipOnly.negotiate(""), ShouldEqual, "")
+			So(gzipOnly.negotiate("gzip, deflate, br"), ShouldEqual, "gzip")
+			So(withBrotli.negotiate("gzip, deflate, br"), ShouldEqual, "br")
+			So(withBrotli.negotiate("br;q=0.5, gzip"), ShouldEqual, "gzip")
+			So(withBrotli.negotiate("br;q=0, gzip;q=0"), ShouldEqual, "")
+			So(gzipOnly.negotiate("identity"), ShouldEqual, "")
+			So(gzipOnly.negotiate("*"), ShouldEqual, "gzip")
+			So(gzipOnly.negotiate("*, gzip;q=0"), ShouldEqual, "")
+		})
+	})
+
+	Convey("An invalid gzip level should be rejected", t, func() {
+		_, err := New(Config{GzipLevel: 12})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+	Convey("Given a compressor with a minimum size of 1KB", t, func() {
+		c, err := New(Config{MinBytes: 1024, Brotli: true})
+		So(err, ShouldBeNil)
+
+		for _, known := range []bool{true, false} {
+			Convey("When a large csv file is requested with gzip, declaring its length: "+strconv.FormatBool(known), func() {
+				resp := serve(c.Handler(textHandler("text/csv; charset=utf-8", known)), "GET", map[string]string{"Accept-Encoding": "gzip"})
+				compressed, err := io.ReadAll(resp.Body)
+				So(err, ShouldBeNil)
+
+				Convey("Then it should be compressed with gzip, with a digest of the compressed body", func() {
+					So(resp.StatusCode, ShouldEqual, http.StatusOK)
+					So(resp.Header.Get("Content-Encoding"), ShouldEqual, "gzip")
+					So(resp.Header.Get("Content-Length"), ShouldBeEmpty)
+					So(resp.Header.Get("Vary"), ShouldEqual, "Accept-Encoding")
+					So(resp.Header.Get("ETag"), ShouldEqual, `"abc-gzip"`)
+
+					gz, err := gzip.NewReader(bytes.NewReader(compressed))
+					So(err, ShouldBeNil)
+					body, err := io.ReadAll(gz)
+					So(err, ShouldBeNil)
+					So(string(body), ShouldEqual, text)
+
+					sum := sha256.Sum256(compressed)
+					So(resp.Trailer.Get("Repr-Digest"), ShouldEqual, "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+				})
+			})
+		}
+
+		Convey("When a large csv file is requested with Brotli", func() {
+			resp := serve(c.Handler(textHandler("text/csv", false)), "GET", map[string]string{"Accept-Encoding": "gzip, br"})
+
+			Convey("Then it should be compressed with Brotli", func() {
+				So(resp.Header.Get("Content-Encoding"), ShouldEqual, "br")
+				body, err := io.ReadAll(brotli.NewReader(resp.Body))
+				So(err, ShouldBeNil)
+				So(string(body), ShouldEqual, text)
+			})
+		})
+
+		Convey("When a large csv file is requested with HEAD", func() {
+			resp := serve(c.Handler(textHandler("text/csv", true)), "HEAD", map[string]string{"Accept-Encoding": "gzip"})
+
+			Convey("Then its headers should describe the compressed response", func() {
+				So(resp.Header.Get("Content-Encoding"), ShouldEqual, "gzip")
+				So(resp.Header.Get("Content-Length"), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a small csv file is requested with gzip", func() {
+			small := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/csv")
+				_, _ = io.WriteString(w, "a,b\n")
+			})
+			resp := serve(c.Handler(small), "GET", map[string]string{"Accept-Encoding": "gzip"})
+			body, _ := io.ReadAll(resp.Body)
+
+			Convey("Then it should be sent uncompressed, with its length", func() {
+				So(resp.Header.Get("Content-Encoding"), ShouldBeEmpty)
+				So(resp.Header.Get("Content-Length"), ShouldEqual, "4")
+				So(resp.Header.Get("Vary"), ShouldEqual, "Accept-Encoding")
+				So(string(body), ShouldEqual, "a,b\n")
+			})
+		})
+
+		Convey("When a large csv file is requested without accepting any encoding", func() {
+			resp := serve(c.Handler(textHandler("text/csv", true)), "GET", nil)
+			body, _ := io.ReadAll(resp.Body)
+
+			Convey("Then it should be sent uncompressed, varying by Accept-Encoding", func() {
+				So(resp.Header.Get("Content-Encoding"), ShouldBeEmpty)
+				So(resp.Header.Get("Vary"), ShouldEqual, "Accept-Encoding")
+				So(resp.Header.Get("ETag"), ShouldEqual, `"abc"`)
+				So(string(body), ShouldEqual, text)
+			})
+		})
+
+		Convey("When a range of a large csv file is requested", func() {
+			ranged := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/csv")
+				w.Header().Set("ETag", `"abc"`)
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(text))
+			})
+
+			Convey("Then the partial response should not be compressed", func() {
+				resp := serve(c.Handler(ranged), "GET", map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-99"})
+				So(resp.StatusCode, ShouldEqual, http.StatusPartialContent)
+				So(resp.Header.Get("Content-Encoding"), ShouldBeEmpty)
+			})
+
+			Convey("Then the whole file sent in its place when If-Range doesn't match should be compressed", func() {
+				resp := serve(c.Handler(ranged), "GET", map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-99", "If-Range": `"old"`})
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Content-Encoding"), ShouldEqual, "gzip")
+				So(resp.Header.Get("Accept-Ranges"), ShouldBeEmpty)
+				reader, err := gzip.NewReader(resp.Body)
+				So(err, ShouldBeNil)
+				body, err := io.ReadAll(reader)
+				So(err, ShouldBeNil)
+				So(string(body), ShouldEqual, text)
+			})
+		})
+
+		Convey("When a large csv file is requested with the ETag of its compressed response", func() {
+			var ifNoneMatch string
+			conditional := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ifNoneMatch = r.Header.Get("If-None-Match")
+				w.Header().Set("ETag", `"abc"`)
+				if ifNoneMatch == `W/"xyz", "abc"` {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				textHandler("text/csv", true).ServeHTTP(w, r)
+			})
+			resp := serve(c.Handler(conditional), "GET", map[string]string{"Accept-Encoding": "gzip", "If-None-Match": `W/"xyz", "abc-gzip"`})
+
+			Convey("Then the handler should be given the ETag of the uncompressed response", func() {
+				So(ifNoneMatch, ShouldEqual, `W/"xyz", "abc"`)
+			})
+
+			Convey("And the response should not be modified, with the ETag the client holds", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusNotModified)
+				So(resp.Header.Get("ETag"), ShouldEqual, `"abc-gzip"`)
+			})
+		})
+
+		Convey("When a large xlsx file is requested with gzip", func() {
+			resp := serve(c.Handler(textHandler("application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", true)), "GET", map[string]string{"Accept-Encoding": "gzip"})
+
+			Convey("Then it should not be compressed, as it already is", func() {
+				So(resp.Header.Get("Content-Encoding"), ShouldBeEmpty)
+				So(resp.Header.Get("Vary"), ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestIsCompressible(t *testing.T) {
+	t.Parallel()
+	Convey("Text content types should be compressible", t, func() {
+		So(isCompressible("text/html; charset=utf-8"), ShouldBeTrue)
+		So(isCompressible("application/json"), ShouldBeTrue)
+		So(isCompressible("application/problem+json"), ShouldBeTrue)
+		So(isCompressible("application/zip"), ShouldBeFalse)
+		So(isCompressible(""), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,184 @@
+package compress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	reprDigestHeader = "Repr-Digest"
+	digestHeader     = "Digest"
+)
+
+// responseWriter compresses the response written to it once it is known to be worth compressing. Responses without
+// a Content-Length are held back until they reach the minimum size, so that small responses are sent as they are.
+type responseWriter struct {
+	http.ResponseWriter
+	compressor *Compressor
+	request    *http.Request
+	// encoding is the encoding negotiated with the client, or empty if the client accepts none
+	encoding string
+	// decodedETags is true if the request was matched against the ETags of uncompressed responses in place of the
+	// compressed responses the client holds, whose ETags are restored when the response is not modified
+	decodedETags bool
+
+	status      int
+	wroteHeader bool
+	// pending is true while the response is held back in buf until it is known whether to compress it
+	pending bool
+	buf     bytes.Buffer
+	encoder io.WriteCloser
+	release func()
+	hash    hash.Hash
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+
+	h := w.Header()
+	if status == http.StatusNotModified && w.decodedETags {
+		h.Set("ETag", encodedETag(h.Get("ETag"), w.encoding))
+	}
+	if !isCompressible(h.Get("Content-Type")) {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	// the response would be compressed for clients accepting another encoding, so caches must key it by Accept-Encoding
+	if !strings.Contains(strings.ToLower(strings.Join(h.Values("Vary"), ",")), "accept-encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+
+	// whether to compress is decided by the response rather than the request: a range request is answered with the
+	// whole file, which is compressed, if the handler ignores the range, e.g. when If-Range doesn't match
+	if w.encoding == "" || status != http.StatusOK || h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	if length := h.Get("Content-Length"); length != "" {
+		size, err := strconv.Atoi(length)
+		if err != nil || size < w.compressor.cfg.MinBytes {
+			w.ResponseWriter.WriteHeader(status)
+			return
+		}
+		w.start()
+		return
+	}
+
+	if w.request.Method == http.MethodHead {
+		// the size of the response can't be known without a body, so it is described as it would be compressed
+		w.start()
+		return
+	}
+	w.pending = true
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case w.pending:
+		w.buf.Write(p)
+		if w.buf.Len() >= w.compressor.cfg.MinBytes {
+			w.start()
+			if err := w.flushBuffer(); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	case w.encoder != nil:
+		return w.encoder.Write(p)
+	default:
+		return w.ResponseWriter.Write(p)
+	}
+}
+
+// Flush sends any compressed output so far to the client, if the underlying writer supports flushing
+func (w *responseWriter) Flush() {
+	if w.pending {
+		return
+	}
+	if f, ok := w.encoder.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer, for use by http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// start writes the headers of a compressed response and, unless it is a response to a HEAD request, begins compressing
+func (w *responseWriter) start() {
+	w.pending = false
+
+	h := w.Header()
+	h.Del("Content-Length")
+	// ranges are served of the uncompressed response only
+	h.Del("Accept-Ranges")
+	h.Set("Content-Encoding", w.encoding)
+	if etag := h.Get("ETag"); etag != "" {
+		h.Set("ETag", encodedETag(etag, w.encoding))
+	}
+	// digests of the uncompressed response don't match the compressed response, which is hashed as it is written and
+	// its digest sent in trailers
+	h.Del(reprDigestHeader)
+	h.Del(digestHeader)
+
+	if w.request.Method == http.MethodHead {
+		w.ResponseWriter.WriteHeader(w.status)
+		return
+	}
+
+	h.Set("Trailer", reprDigestHeader+", "+digestHeader)
+	w.ResponseWriter.WriteHeader(w.status)
+	w.hash = sha256.New()
+	w.encoder, w.release = w.compressor.encoder(w.encoding, io.MultiWriter(w.ResponseWriter, w.hash))
+}
+
+func (w *responseWriter) flushBuffer() error {
+	_, err := w.encoder.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// close completes the response: a response that never reached the minimum size is sent uncompressed, and a
+// compressed response is finished and its digest sent
+func (w *responseWriter) close() {
+	if w.pending {
+		w.pending = false
+		h := w.Header()
+		h.Set("Content-Length", strconv.Itoa(w.buf.Len()))
+		h.Del("Trailer")
+		w.ResponseWriter.WriteHeader(w.status)
+		_, _ = w.ResponseWriter.Write(w.buf.Bytes())
+		return
+	}
+	if w.encoder == nil {
+		return
+	}
+
+	err := w.encoder.Close()
+	w.release()
+	w.encoder = nil
+	if err != nil {
+		return
+	}
+	sum := base64.StdEncoding.EncodeToString(w.hash.Sum(nil))
+	w.Header().Set(reprDigestHeader, "sha-256=:"+sum+":")
+	w.Header().Set(digestHeader, "SHA-256="+sum)
+}
@@ -10,18 +10,60 @@ import (
 
 // Config is the configuration for this service
 type Config struct {
-	BindAddr                   string        `envconfig:"BIND_ADDR"`
-	CORSAllowedOrigins         string        `envconfig:"CORS_ALLOWED_ORIGINS"`
-	ShutdownTimeout            time.Duration `envconfig:"SHUTDOWN_TIMEOUT"`
-	HealthCheckCriticalTimeout time.Duration `envconfig:"HEALTHCHECK_CRITICAL_TIMEOUT"`
-	HealthCheckInterval        time.Duration `envconfig:"HEALTHCHECK_INTERVAL"`
-	OTBatchTimeout             time.Duration `encconfig:"OTEL_BATCH_TIMEOUT"`
-	OTServiceName              string        `envconfig:"OTEL_SERVICE_NAME"`
-	OTExporterOTLPEndpoint     string        `envconfig:"OTEL_EXPORTER_OTLP_ENDPOINT"`
-	OtelEnabled                bool          `envconfig:"OTEL_ENABLED"`
-	TableRendererHost          string        `envconfig:"TABLE_RENDERER_HOST"`
-	ContentServerHost          string        `envconfig:"CONTENT_SERVER_HOST"`
-	APIRouterURL               string        `envconfig:"API_ROUTER_URL"`
+	BindAddr                   string                   `envconfig:"BIND_ADDR"`
+	CORSAllowedOrigins         string                   `envconfig:"CORS_ALLOWED_ORIGINS"`
+	ShutdownTimeout            time.Duration            `envconfig:"SHUTDOWN_TIMEOUT"`
+	HealthCheckCriticalTimeout time.Duration            `envconfig:"HEALTHCHECK_CRITICAL_TIMEOUT"`
+	HealthCheckInterval        time.Duration            `envconfig:"HEALTHCHECK_INTERVAL"`
+	OTBatchTimeout             time.Duration            `encconfig:"OTEL_BATCH_TIMEOUT"`
+	OTServiceName              string                   `envconfig:"OTEL_SERVICE_NAME"`
+	OTExporterOTLPEndpoint     string                   `envconfig:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	OtelEnabled                bool                     `envconfig:"OTEL_ENABLED"`
+	TableRendererHost          string                   `envconfig:"TABLE_RENDERER_HOST"`
+	ContentServerHost          string                   `envconfig:"CONTENT_SERVER_HOST"`
+	APIRouterURL               string                   `envconfig:"API_ROUTER_URL"`
+	LocalContentDir            string                   `envconfig:"LOCAL_CONTENT_DIR"`
+	SiteURL                    string                   `envconfig:"SITE_URL"`
+	CacheMaxAge                time.Duration            `envconfig:"CACHE_MAX_AGE"`
+	CacheMaxAgeByType          map[string]time.Duration `envconfig:"CACHE_MAX_AGE_BY_TYPE"`
+	CacheSurrogateMaxAge       time.Duration            `envconfig:"CACHE_SURROGATE_MAX_AGE"`
+	CacheSurrogateMaxAgeByType map[string]time.Duration `envconfig:"CACHE_SURROGATE_MAX_AGE_BY_TYPE"`
+	CacheEnabled               bool                     `envconfig:"CACHE_ENABLED"`
+	CacheMaxBytes              int64                    `envconfig:"CACHE_MAX_BYTES"`
+	CacheMaxEntryBytes         int64                    `envconfig:"CACHE_MAX_ENTRY_BYTES"`
+	CacheTTL                   time.Duration            `envconfig:"CACHE_TTL"`
+	CacheMemoryMaxEntryBytes   int64                    `envconfig:"CACHE_MEMORY_MAX_ENTRY_BYTES"`
+	CacheDiskDir               string                   `envconfig:"CACHE_DISK_DIR"`
+	CacheDiskMaxBytes          int64                    `envconfig:"CACHE_DISK_MAX_BYTES"`
+	CacheWarmType              string                   `envconfig:"CACHE_WARM_TYPE"`
+	CacheWarmFormats           []string                 `envconfig:"CACHE_WARM_FORMATS"`
+	CacheWarmLanguages         []string                 `envconfig:"CACHE_WARM_LANGUAGES"`
+	CacheWarmConcurrency       int                      `envconfig:"CACHE_WARM_CONCURRENCY"`
+	CacheWarmRate              float64                  `envconfig:"CACHE_WARM_RATE"`
+	CacheWarmQueueSize         int                      `envconfig:"CACHE_WARM_QUEUE_SIZE"`
+	LimitMaxInFlight           map[string]int           `envconfig:"LIMIT_MAX_IN_FLIGHT"`
+	LimitMaxQueue              int                      `envconfig:"LIMIT_MAX_QUEUE"`
+	LimitQueueTimeout          time.Duration            `envconfig:"LIMIT_QUEUE_TIMEOUT"`
+	LimitRetryAfter            time.Duration            `envconfig:"LIMIT_RETRY_AFTER"`
+	LimitWarnRejected          int                      `envconfig:"LIMIT_WARN_REJECTED"`
+	DownloadTimeout            time.Duration            `envconfig:"DOWNLOAD_TIMEOUT"`
+	DownloadTimeoutByType      map[string]time.Duration `envconfig:"DOWNLOAD_TIMEOUT_BY_TYPE"`
+	HTTPWriteTimeout           time.Duration            `envconfig:"HTTP_WRITE_TIMEOUT"`
+	DrainDelay                 time.Duration            `envconfig:"DRAIN_DELAY"`
+	DrainTimeout               time.Duration            `envconfig:"DRAIN_TIMEOUT"`
+	RateLimitEnabled           bool                     `envconfig:"RATE_LIMIT_ENABLED"`
+	RateLimitRate              float64                  `envconfig:"RATE_LIMIT_RATE"`
+	RateLimitRateByType        map[string]float64       `envconfig:"RATE_LIMIT_RATE_BY_TYPE"`
+	RateLimitBurst             int                      `envconfig:"RATE_LIMIT_BURST"`
+	RateLimitTrustedProxies    []string                 `envconfig:"RATE_LIMIT_TRUSTED_PROXIES"`
+	RateLimitAPIKeyHeader      string                   `envconfig:"RATE_LIMIT_API_KEY_HEADER"`
+	RateLimitAPIKeys           []string                 `envconfig:"RATE_LIMIT_API_KEYS" json:"-"`
+	RateLimitAllowList         []string                 `envconfig:"RATE_LIMIT_ALLOW_LIST" json:"-"`
+	CompressionEnabled         bool                     `envconfig:"COMPRESSION_ENABLED"`
+	CompressionMinBytes        int                      `envconfig:"COMPRESSION_MIN_BYTES"`
+	CompressionBrotli          bool                     `envconfig:"COMPRESSION_BROTLI"`
+	CompressionGzipLevel       int                      `envconfig:"COMPRESSION_GZIP_LEVEL"`
+	AdminAuthToken             string                   `envconfig:"ADMIN_AUTH_TOKEN" json:"-"`
 }
 
 var cfg *Config
@@ -44,6 +86,40 @@ func Get() (*Config, error) {
 		OtelEnabled:                false,
 		TableRendererHost:          "http://localhost:23300",
 		APIRouterURL:               "http://localhost:23200/v1",
+		SiteURL:                    "https://www.ons.gov.uk",
+		CacheMaxAge:                5 * time.Minute,
+		CacheSurrogateMaxAge:       time.Hour,
+		CacheEnabled:               false,
+		CacheMaxBytes:              128 << 20,
+		CacheMaxEntryBytes:         16 << 20,
+		CacheTTL:                   time.Hour,
+		CacheMemoryMaxEntryBytes:   1 << 20,
+		CacheDiskMaxBytes:          1 << 30,
+		CacheWarmType:              "table",
+		CacheWarmFormats:           []string{"xlsx", "csv"},
+		CacheWarmLanguages:         []string{"en", "cy"},
+		CacheWarmConcurrency:       2,
+		CacheWarmRate:              5,
+		CacheWarmQueueSize:         1000,
+		LimitMaxInFlight:           map[string]int{"table": 50, "table/xlsx": 10},
+		LimitMaxQueue:              20,
+		LimitQueueTimeout:          5 * time.Second,
+		LimitRetryAfter:            10 * time.Second,
+		LimitWarnRejected:          10,
+		DownloadTimeout:            30 * time.Second,
+		DownloadTimeoutByType:      map[string]time.Duration{"table": 60 * time.Second},
+		HTTPWriteTimeout:           5 * time.Minute,
+		DrainDelay:                 15 * time.Second,
+		DrainTimeout:               2 * time.Minute,
+		RateLimitEnabled:           false,
+		RateLimitRate:              5,
+		RateLimitBurst:             20,
+		RateLimitAPIKeyHeader:      "X-API-Key",
+		RateLimitAllowList:         []string{"127.0.0.1", "::1"},
+		CompressionEnabled:         true,
+		CompressionMinBytes:        1024,
+		CompressionBrotli:          false,
+		CompressionGzipLevel:       0,
 	}
 
 	return cfg, envconfig.Process("", cfg)
@@ -60,5 +136,44 @@ func (cfg *Config) Log(ctx context.Context) {
 		"TableRendererHost":          cfg.TableRendererHost,
 		"ContentServerHost":          cfg.ContentServerHost,
 		"APIRouterURL":               cfg.APIRouterURL,
+		"LocalContentDir":            cfg.LocalContentDir,
+		"SiteURL":                    cfg.SiteURL,
+		"CacheMaxAge":                cfg.CacheMaxAge,
+		"CacheMaxAgeByType":          cfg.CacheMaxAgeByType,
+		"CacheSurrogateMaxAge":       cfg.CacheSurrogateMaxAge,
+		"CacheSurrogateMaxAgeByType": cfg.CacheSurrogateMaxAgeByType,
+		"CacheEnabled":               cfg.CacheEnabled,
+		"CacheMaxBytes":              cfg.CacheMaxBytes,
+		"CacheMaxEntryBytes":         cfg.CacheMaxEntryBytes,
+		"CacheTTL":                   cfg.CacheTTL,
+		"CacheMemoryMaxEntryBytes":   cfg.CacheMemoryMaxEntryBytes,
+		"CacheDiskDir":               cfg.CacheDiskDir,
+		"CacheDiskMaxBytes":          cfg.CacheDiskMaxBytes,
+		"CacheWarmType":              cfg.CacheWarmType,
+		"CacheWarmFormats":           cfg.CacheWarmFormats,
+		"CacheWarmLanguages":         cfg.CacheWarmLanguages,
+		"CacheWarmConcurrency":       cfg.CacheWarmConcurrency,
+		"CacheWarmRate":              cfg.CacheWarmRate,
+		"CacheWarmQueueSize":         cfg.CacheWarmQueueSize,
+		"LimitMaxInFlight":           cfg.LimitMaxInFlight,
+		"LimitMaxQueue":              cfg.LimitMaxQueue,
+		"LimitQueueTimeout":          cfg.LimitQueueTimeout,
+		"LimitRetryAfter":            cfg.LimitRetryAfter,
+		"LimitWarnRejected":          cfg.LimitWarnRejected,
+		"DownloadTimeout":            cfg.DownloadTimeout,
+		"DownloadTimeoutByType":      cfg.DownloadTimeoutByType,
+		"HTTPWriteTimeout":           cfg.HTTPWriteTimeout,
+		"DrainDelay":                 cfg.DrainDelay,
+		"DrainTimeout":               cfg.DrainTimeout,
+		"RateLimitEnabled":           cfg.RateLimitEnabled,
+		"RateLimitRate":              cfg.RateLimitRate,
+		"RateLimitRateByType":        cfg.RateLimitRateByType,
+		"RateLimitBurst":             cfg.RateLimitBurst,
+		"RateLimitTrustedProxies":    cfg.RateLimitTrustedProxies,
+		"RateLimitAPIKeyHeader":      cfg.RateLimitAPIKeyHeader,
+		"CompressionEnabled":         cfg.CompressionEnabled,
+		"CompressionMinBytes":        cfg.CompressionMinBytes,
+		"CompressionBrotli":          cfg.CompressionBrotli,
+		"CompressionGzipLevel":       cfg.CompressionGzipLevel,
 	})
 }
@@ -22,8 +22,45 @@ func TestSpec(t *testing.T) {
 				So(cfg.CORSAllowedOrigins, ShouldEqual, "*")
 				So(cfg.TableRendererHost, ShouldEqual, "http://localhost:23300")
 				So(cfg.APIRouterURL, ShouldEqual, "http://localhost:23200/v1")
+				So(cfg.LocalContentDir, ShouldEqual, "")
 				So(cfg.HealthCheckCriticalTimeout, ShouldEqual, 90*time.Second)
 				So(cfg.HealthCheckInterval, ShouldEqual, 30*time.Second)
+				So(cfg.SiteURL, ShouldEqual, "https://www.ons.gov.uk")
+				So(cfg.CacheMaxAge, ShouldEqual, 5*time.Minute)
+				So(cfg.CacheSurrogateMaxAge, ShouldEqual, time.Hour)
+				So(cfg.CacheEnabled, ShouldBeFalse)
+				So(cfg.CacheMaxBytes, ShouldEqual, 128<<20)
+				So(cfg.CacheMaxEntryBytes, ShouldEqual, 16<<20)
+				So(cfg.CacheTTL, ShouldEqual, time.Hour)
+				So(cfg.CacheMemoryMaxEntryBytes, ShouldEqual, 1<<20)
+				So(cfg.CacheDiskDir, ShouldBeEmpty)
+				So(cfg.CacheDiskMaxBytes, ShouldEqual, 1<<30)
+				So(cfg.CacheWarmType, ShouldEqual, "table")
+				So(cfg.CacheWarmFormats, ShouldResemble, []string{"xlsx", "csv"})
+				So(cfg.CacheWarmLanguages, ShouldResemble, []string{"en", "cy"})
+				So(cfg.CacheWarmConcurrency, ShouldEqual, 2)
+				So(cfg.CacheWarmRate, ShouldEqual, 5)
+				So(cfg.CacheWarmQueueSize, ShouldEqual, 1000)
+				So(cfg.LimitMaxInFlight, ShouldResemble, map[string]int{"table": 50, "table/xlsx": 10})
+				So(cfg.LimitMaxQueue, ShouldEqual, 20)
+				So(cfg.LimitQueueTimeout, ShouldEqual, 5*time.Second)
+				So(cfg.LimitRetryAfter, ShouldEqual, 10*time.Second)
+				So(cfg.LimitWarnRejected, ShouldEqual, 10)
+				So(cfg.DownloadTimeout, ShouldEqual, 30*time.Second)
+				So(cfg.DownloadTimeoutByType, ShouldResemble, map[string]time.Duration{"table": 60 * time.Second})
+				So(cfg.HTTPWriteTimeout, ShouldEqual, 5*time.Minute)
+				So(cfg.DrainDelay, ShouldEqual, 15*time.Second)
+				So(cfg.DrainTimeout, ShouldEqual, 2*time.Minute)
+				So(cfg.RateLimitEnabled, ShouldBeFalse)
+				So(cfg.RateLimitRate, ShouldEqual, 5)
+				So(cfg.RateLimitBurst, ShouldEqual, 20)
+				So(cfg.RateLimitAPIKeyHeader, ShouldEqual, "X-API-Key")
+				So(cfg.RateLimitAllowList, ShouldResemble, []string{"127.0.0.1", "::1"})
+				So(cfg.CompressionEnabled, ShouldBeTrue)
+				So(cfg.CompressionMinBytes, ShouldEqual, 1024)
+				So(cfg.CompressionBrotli, ShouldBeFalse)
+				So(cfg.CompressionGzipLevel, ShouldEqual, 0)
+				So(cfg.AdminAuthToken, ShouldBeEmpty)
 			})
 		})
 	})
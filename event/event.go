@@ -0,0 +1,108 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/ONSdigital/log.go/v2/log"
+)
+
+// ContentPublished is the event sent when a collection is published, listing the uris of the content it contained
+type ContentPublished struct {
+	CollectionID string   `json:"collection_id"`
+	URIs         []string `json:"uris"`
+}
+
+// Consumer provides content-published events from a message broker or other source
+type Consumer interface {
+	// Channel returns the channel on which events are received. It is closed when the consumer is closed.
+	Channel() <-chan *ContentPublished
+	// Close stops the consumer receiving events
+	Close(ctx context.Context) error
+}
+
+// Handler processes a content-published event
+type Handler func(ctx context.Context, event *ContentPublished) error
+
+// Consume passes every event received by the consumer to each of the handlers, until the consumer's channel
+// is closed or the context is done. Errors returned by handlers are logged and don't stop consumption.
+func Consume(ctx context.Context, consumer Consumer, handlers ...Handler) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-consumer.Channel():
+			if !ok {
+				return
+			}
+			for _, handle := range handlers {
+				if err := handle(ctx, e); err != nil {
+					log.Error(ctx, "error handling content published event", err, log.Data{"collection_id": e.CollectionID, "uris": e.URIs})
+				}
+			}
+		}
+	}
+}
+
+// ErrConsumerClosed is returned when publishing to a closed ChannelConsumer
+var ErrConsumerClosed = errors.New("consumer is closed")
+
+// ChannelConsumer is an in-process Consumer, to which events are published directly
+type ChannelConsumer struct {
+	mu      sync.RWMutex
+	events  chan *ContentPublished
+	done    chan struct{}
+	closed  bool
+	pending sync.WaitGroup
+	once    sync.Once
+}
+
+var _ Consumer = &ChannelConsumer{}
+
+// NewChannelConsumer returns a ChannelConsumer buffering up to size events
+func NewChannelConsumer(size int) *ChannelConsumer {
+	return &ChannelConsumer{events: make(chan *ContentPublished, size), done: make(chan struct{})}
+}
+
+// Publish sends the event to the consumer, blocking if its buffer is full until the context is done or the consumer
+// is closed. The lock is only held to register the publish, so a blocked publish never holds up Close.
+func (c *ChannelConsumer) Publish(ctx context.Context, event *ContentPublished) error {
+	c.mu.RLock()
+	if c.closed {
+		c.mu.RUnlock()
+		return ErrConsumerClosed
+	}
+	c.pending.Add(1)
+	c.mu.RUnlock()
+	defer c.pending.Done()
+
+	select {
+	case c.events <- event:
+		return nil
+	case <-c.done:
+		return ErrConsumerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Channel returns the channel on which published events are received
+func (c *ChannelConsumer) Channel() <-chan *ContentPublished {
+	return c.events
+}
+
+// Close closes the consumer's channel, once any blocked publishes have returned. Events already published are still
+// received.
+func (c *ChannelConsumer) Close(ctx context.Context) error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+	c.mu.Unlock()
+
+	c.pending.Wait()
+	c.once.Do(func() { close(c.events) })
+	return nil
+}
@@ -0,0 +1,65 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConsume(t *testing.T) {
+	t.Parallel()
+	Convey("Given a channel consumer with published events", t, func() {
+		ctx := context.Background()
+		consumer := NewChannelConsumer(2)
+		So(consumer.Publish(ctx, &ContentPublished{CollectionID: "a", URIs: []string{"/a"}}), ShouldBeNil)
+		So(consumer.Publish(ctx, &ContentPublished{CollectionID: "b", URIs: []string{"/b"}}), ShouldBeNil)
+		So(consumer.Close(ctx), ShouldBeNil)
+
+		Convey("When the events are consumed by handlers, one of which fails", func() {
+			var handled []string
+			failing := func(ctx context.Context, e *ContentPublished) error { return errors.New("failed") }
+			recording := func(ctx context.Context, e *ContentPublished) error {
+				handled = append(handled, e.CollectionID)
+				return nil
+			}
+			Consume(ctx, consumer, failing, recording)
+
+			Convey("Every event should be passed to every handler until the consumer is closed", func() {
+				So(handled, ShouldResemble, []string{"a", "b"})
+			})
+		})
+
+		Convey("When an event is published after the consumer is closed", func() {
+			err := consumer.Publish(ctx, &ContentPublished{})
+
+			Convey("An error should be returned", func() {
+				So(err, ShouldEqual, ErrConsumerClosed)
+			})
+		})
+	})
+
+	Convey("Given a channel consumer whose buffer is full", t, func() {
+		ctx := context.Background()
+		consumer := NewChannelConsumer(1)
+		So(consumer.Publish(ctx, &ContentPublished{CollectionID: "a"}), ShouldBeNil)
+
+		Convey("When it is closed while an event is waiting to be published", func() {
+			published := make(chan error, 1)
+			go func() {
+				published <- consumer.Publish(ctx, &ContentPublished{CollectionID: "b"})
+			}()
+			So(consumer.Close(ctx), ShouldBeNil)
+
+			Convey("The waiting publish should fail, and events already published should still be received", func() {
+				So(<-published, ShouldEqual, ErrConsumerClosed)
+				e, ok := <-consumer.Channel()
+				So(ok, ShouldBeTrue)
+				So(e.CollectionID, ShouldEqual, "a")
+				_, ok = <-consumer.Channel()
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -12,6 +12,8 @@ require (
 	github.com/smartystreets/goconvey v1.8.1
 )
 
+require github.com/andybalholm/brotli v1.2.0
+
 require (
 	github.com/ONSdigital/dp-otel-go v0.0.8
 	github.com/cenkalti/backoff/v4 v4.3.0 // indirect
@@ -47,7 +49,7 @@ require (
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/net v0.39.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
-	golang.org/x/text v0.24.0 // indirect
+	golang.org/x/text v0.24.0
 	google.golang.org/genproto/googleapis/api v0.0.0-20250407143221-ac9807e6c755 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20250407143221-ac9807e6c755 // indirect
 	google.golang.org/grpc v1.71.1 // indirect
@@ -0,0 +1,184 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ONSdigital/dp-healthcheck/healthcheck"
+)
+
+var (
+	// ErrQueueFull is returned when a request can't be queued because the queue is full
+	ErrQueueFull = errors.New("too many requests are waiting to be processed")
+	// ErrQueueTimeout is returned when a request waits too long in the queue
+	ErrQueueTimeout = errors.New("timed out waiting for a request to complete")
+)
+
+// Limiter bounds the number of requests processed at once, queueing a bounded number of further requests until
+// a request completes
+type Limiter struct {
+	slots        chan struct{}
+	maxQueue     int64
+	queueTimeout time.Duration
+	queued       atomic.Int64
+	rejected     atomic.Int64
+}
+
+// Stats describes the current load on a Limiter
+type Stats struct {
+	MaxInFlight int   `json:"max_in_flight"`
+	InFlight    int   `json:"in_flight"`
+	MaxQueue    int   `json:"max_queue"`
+	Queued      int   `json:"queued"`
+	Rejected    int64 `json:"rejected"`
+}
+
+// NewLimiter returns a Limiter processing up to maxInFlight requests at once, with up to maxQueue requests waiting
+// for up to queueTimeout each
+func NewLimiter(maxInFlight, maxQueue int, queueTimeout time.Duration) *Limiter {
+	return &Limiter{
+		slots:        make(chan struct{}, maxInFlight),
+		maxQueue:     int64(maxQueue),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// Acquire waits for a request to be allowed to be processed, returning a function that must be called once it has been.
+// ErrQueueFull or ErrQueueTimeout is returned if the request must be rejected.
+func (l *Limiter) Acquire(ctx context.Context) (release func(), err error) {
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	default:
+	}
+
+	if l.queued.Add(1) > l.maxQueue {
+		l.queued.Add(-1)
+		l.rejected.Add(1)
+		return nil, ErrQueueFull
+	}
+	defer l.queued.Add(-1)
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	case <-timer.C:
+		l.rejected.Add(1)
+		return nil, ErrQueueTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *Limiter) release() {
+	<-l.slots
+}
+
+// Stats returns the current load on the Limiter
+func (l *Limiter) Stats() Stats {
+	return Stats{
+		MaxInFlight: cap(l.slots),
+		InFlight:    len(l.slots),
+		MaxQueue:    int(l.maxQueue),
+		Queued:      int(l.queued.Load()),
+		Rejected:    l.rejected.Load(),
+	}
+}
+
+// Limits holds the limiters for downloader types, and for formats of a downloader type
+type Limits struct {
+	limiters map[string]*Limiter
+	// warnRejected is the number of requests rejected between health checks that is reported as a warning
+	warnRejected int64
+	lastRejected atomic.Int64
+}
+
+// NewLimits returns a Limiter for each entry of maxInFlight, which is keyed by downloader type (e.g. "table") or by
+// downloader type and format (e.g. "table/xlsx"). Each limiter queues up to maxQueue requests for up to queueTimeout.
+// The health check warns once warnRejected requests (at least one) have been rejected since it last ran.
+func NewLimits(maxInFlight map[string]int, maxQueue int, queueTimeout time.Duration, warnRejected int) *Limits {
+	limits := &Limits{limiters: make(map[string]*Limiter), warnRejected: max(int64(warnRejected), 1)}
+	for name, max := range maxInFlight {
+		if max > 0 {
+			limits.limiters[name] = NewLimiter(max, maxQueue, queueTimeout)
+		}
+	}
+	return limits
+}
+
+// Acquire waits for both the limit of the format and the limit of the downloader type to allow the request to be
+// processed, returning a function that must be called once it has been. The narrower limit of the format is acquired
+// first, so that requests queued behind a busy format don't hold slots that other formats of the type could use.
+func (l *Limits) Acquire(ctx context.Context, downloaderType, format string) (release func(), err error) {
+	var releases []func()
+	release = func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+
+	names := []string{downloaderType}
+	if format != "" {
+		names = append([]string{downloaderType + "/" + format}, names...)
+	}
+	for _, name := range names {
+		limiter, ok := l.limiters[name]
+		if !ok {
+			continue
+		}
+		r, err := limiter.Acquire(ctx)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+	return release, nil
+}
+
+// Stats returns the current load on each limiter, keyed by its name
+func (l *Limits) Stats() map[string]Stats {
+	stats := make(map[string]Stats, len(l.limiters))
+	for name, limiter := range l.limiters {
+		stats[name] = limiter.Stats()
+	}
+	return stats
+}
+
+// Checker reports a warning if at least the configured number of requests have been rejected since the last check
+func (l *Limits) Checker(ctx context.Context, state *healthcheck.CheckState) error {
+	stats := l.Stats()
+	names := make([]string, 0, len(stats))
+	var rejected int64
+	for name, s := range stats {
+		names = append(names, name)
+		rejected += s.Rejected
+	}
+	sort.Strings(names)
+
+	var summary []string
+	for _, name := range names {
+		s := stats[name]
+		summary = append(summary, fmt.Sprintf("%s: %d/%d in flight, %d/%d queued, %d rejected", name, s.InFlight, s.MaxInFlight, s.Queued, s.MaxQueue, s.Rejected))
+	}
+	message := strings.Join(summary, "; ")
+
+	recent := rejected - l.lastRejected.Swap(rejected)
+	if recent >= l.warnRejected {
+		return state.Update(healthcheck.StatusWarning, fmt.Sprintf("%d downloads rejected since last check; %s", recent, message), 0)
+	}
+	if recent > 0 {
+		message = fmt.Sprintf("%d downloads rejected since last check; %s", recent, message)
+	}
+	if message == "" {
+		message = "no download limits configured"
+	}
+	return state.Update(healthcheck.StatusOK, message, 0)
+}
@@ -0,0 +1,152 @@
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-healthcheck/healthcheck"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var ctx = context.Background()
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+	Convey("Given a limiter allowing one request in flight and one queued", t, func() {
+		limiter := NewLimiter(1, 1, 50*time.Millisecond)
+		release, err := limiter.Acquire(ctx)
+		So(err, ShouldBeNil)
+
+		Convey("When a second request is released while it is queued", func() {
+			acquired := make(chan error)
+			go func() {
+				_, err := limiter.Acquire(ctx)
+				acquired <- err
+			}()
+			So(waitFor(func() bool { return limiter.Stats().Queued == 1 }), ShouldBeTrue)
+			release()
+
+			Convey("It should be processed", func() {
+				So(<-acquired, ShouldBeNil)
+				So(limiter.Stats().InFlight, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When further requests wait too long or the queue is full", func() {
+			timedOut := make(chan error)
+			go func() {
+				_, err := limiter.Acquire(ctx)
+				timedOut <- err
+			}()
+			So(waitFor(func() bool { return limiter.Stats().Queued == 1 }), ShouldBeTrue)
+			_, err := limiter.Acquire(ctx)
+
+			Convey("They should be rejected", func() {
+				So(err, ShouldEqual, ErrQueueFull)
+				So(<-timedOut, ShouldEqual, ErrQueueTimeout)
+				So(limiter.Stats(), ShouldResemble, Stats{MaxInFlight: 1, InFlight: 1, MaxQueue: 1, Queued: 0, Rejected: 2})
+			})
+		})
+	})
+}
+
+func TestLimits(t *testing.T) {
+	t.Parallel()
+	Convey("Given limits for tables and for xlsx tables with a queue", t, func() {
+		limits := NewLimits(map[string]int{"table": 2, "table/xlsx": 1}, 2, time.Minute, 1)
+
+		Convey("When xlsx tables are queued behind one being downloaded", func() {
+			release, err := limits.Acquire(ctx, "table", "xlsx")
+			So(err, ShouldBeNil)
+			acquired := make(chan error, 2)
+			for range 2 {
+				go func() {
+					releaseXLSX, err := limits.Acquire(ctx, "table", "xlsx")
+					if err == nil {
+						releaseXLSX()
+					}
+					acquired <- err
+				}()
+			}
+			So(waitFor(func() bool { return limits.Stats()["table/xlsx"].Queued == 2 }), ShouldBeTrue)
+
+			Convey("They should not hold table slots while they wait, so a csv table is allowed", func() {
+				So(limits.Stats()["table"].InFlight, ShouldEqual, 1)
+				releaseCSV, err := limits.Acquire(ctx, "table", "csv")
+				So(err, ShouldBeNil)
+				releaseCSV()
+				release()
+				So(<-acquired, ShouldBeNil)
+				So(<-acquired, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given limits for tables and for xlsx tables", t, func() {
+		limits := NewLimits(map[string]int{"table": 2, "table/xlsx": 1}, 0, time.Millisecond, 2)
+
+		Convey("When an xlsx table is being downloaded", func() {
+			release, err := limits.Acquire(ctx, "table", "xlsx")
+			So(err, ShouldBeNil)
+
+			Convey("Another xlsx table should be rejected, but a csv table allowed", func() {
+				_, err = limits.Acquire(ctx, "table", "xlsx")
+				So(err, ShouldEqual, ErrQueueFull)
+				releaseCSV, err := limits.Acquire(ctx, "table", "csv")
+				So(err, ShouldBeNil)
+				releaseCSV()
+			})
+
+			Convey("The table limit should not be held by the rejected download", func() {
+				_, err = limits.Acquire(ctx, "table", "xlsx")
+				So(err, ShouldEqual, ErrQueueFull)
+				So(limits.Stats()["table"].InFlight, ShouldEqual, 1)
+			})
+
+			Convey("Once it completes, another xlsx table should be allowed", func() {
+				release()
+				_, err = limits.Acquire(ctx, "table", "xlsx")
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When downloads have been rejected", func() {
+			release, err := limits.Acquire(ctx, "table", "xlsx")
+			So(err, ShouldBeNil)
+			defer release()
+			state := healthcheck.NewCheckState("download limits")
+
+			Convey("The health check should not warn about fewer rejections than the threshold", func() {
+				_, err = limits.Acquire(ctx, "table", "xlsx")
+				So(err, ShouldEqual, ErrQueueFull)
+				So(limits.Checker(ctx, state), ShouldBeNil)
+				So(state.Status(), ShouldEqual, healthcheck.StatusOK)
+				So(state.Message(), ShouldContainSubstring, "1 downloads rejected since last check")
+			})
+
+			Convey("The health check should warn once about as many rejections as the threshold", func() {
+				for range 2 {
+					_, err = limits.Acquire(ctx, "table", "xlsx")
+					So(err, ShouldEqual, ErrQueueFull)
+				}
+				So(limits.Checker(ctx, state), ShouldBeNil)
+				So(state.Status(), ShouldEqual, healthcheck.StatusWarning)
+				So(state.Message(), ShouldContainSubstring, "2 downloads rejected since last check")
+
+				So(limits.Checker(ctx, state), ShouldBeNil)
+				So(state.Status(), ShouldEqual, healthcheck.StatusOK)
+			})
+		})
+	})
+}
+
+// waitFor polls the condition until it is true, or a second has passed
+func waitFor(condition func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return condition()
+}
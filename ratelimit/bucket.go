@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets that have refilled are discarded, to bound memory use
+const sweepInterval = time.Minute
+
+// bucket is a token bucket, refilled at a constant rate up to its burst size
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// buckets holds a token bucket per client
+type buckets struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newBuckets(rate float64, burst int, now func() time.Time) *buckets {
+	return &buckets{
+		rate:      rate,
+		burst:     float64(burst),
+		now:       now,
+		clients:   make(map[string]*bucket),
+		lastSweep: now(),
+	}
+}
+
+// take removes a token from the client's bucket, returning whether there was one, the number of tokens remaining and
+// how long until the bucket is full again
+func (b *buckets) take(client string) (allowed bool, remaining int, reset time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if now.Sub(b.lastSweep) > sweepInterval {
+		b.sweep(now)
+	}
+
+	c, ok := b.clients[client]
+	if !ok {
+		c = &bucket{tokens: b.burst, updated: now}
+		b.clients[client] = c
+	}
+	c.tokens = math.Min(b.burst, c.tokens+now.Sub(c.updated).Seconds()*b.rate)
+	c.updated = now
+
+	if c.tokens >= 1 {
+		c.tokens--
+		allowed = true
+	}
+	reset = time.Duration((b.burst - c.tokens) / b.rate * float64(time.Second))
+	return allowed, int(c.tokens), reset
+}
+
+// sweep discards the buckets that would be full by now, as they are equivalent to new buckets.
+// The caller must hold the lock.
+func (b *buckets) sweep(now time.Time) {
+	for client, c := range b.clients {
+		if c.tokens+now.Sub(c.updated).Seconds()*b.rate >= b.burst {
+			delete(b.clients, client)
+		}
+	}
+	b.lastSweep = now
+}
@@ -0,0 +1,216 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/log.go/v2/log"
+)
+
+// Config configures the rate limits applied to clients
+type Config struct {
+	// Rate is the number of requests per second each client may make for a downloader type, across all of its paths,
+	// unless overridden by RateByType
+	Rate       float64
+	RateByType map[string]float64
+	// Burst is the number of requests a client may make at once, before being limited to the rate
+	Burst int
+	// TrustedProxies are the networks of proxies whose X-Forwarded-For header identifies the client
+	TrustedProxies []string
+	// APIKeyHeader is the header in which clients may send an API key
+	APIKeyHeader string
+	// APIKeys are the API keys that are rate limited separately from the IP address of the client
+	APIKeys []string
+	// AllowList contains the IP addresses, networks and API keys of clients that are not rate limited. API keys must
+	// also be in APIKeys, so that a mistyped address is reported rather than taken for a key.
+	AllowList []string
+}
+
+type exemptKey struct{}
+
+// Exempt returns a copy of the context marking requests made with it as internal, e.g. to warm the cache, so that
+// they are never rate limited
+func Exempt(ctx context.Context) context.Context {
+	return context.WithValue(ctx, exemptKey{}, true)
+}
+
+func isExempt(ctx context.Context) bool {
+	exempt, _ := ctx.Value(exemptKey{}).(bool)
+	return exempt
+}
+
+// RateLimiter limits the rate of requests made by each client, identified by API key or IP address
+type RateLimiter struct {
+	cfg            Config
+	trustedProxies []*net.IPNet
+	allowedNets    []*net.IPNet
+	allowedKeys    map[string]bool
+	apiKeys        map[string]bool
+	now            func() time.Time
+}
+
+// New returns a RateLimiter, or an error if the configured networks are invalid or the allow list contains an entry that
+// is neither a network nor an API key
+func New(cfg Config) (*RateLimiter, error) {
+	l := &RateLimiter{
+		cfg:         cfg,
+		allowedKeys: make(map[string]bool),
+		apiKeys:     make(map[string]bool),
+		now:         time.Now,
+	}
+
+	var err error
+	if l.trustedProxies, err = parseNetworks(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+	}
+	for _, key := range cfg.APIKeys {
+		l.apiKeys[key] = true
+	}
+	for _, entry := range cfg.AllowList {
+		network, err := parseNetwork(entry)
+		switch {
+		case err == nil:
+			l.allowedNets = append(l.allowedNets, network)
+		case l.apiKeys[entry]:
+			l.allowedKeys[entry] = true
+		default:
+			return nil, fmt.Errorf("invalid allow list entry, which is neither a network nor an API key: %w", err)
+		}
+	}
+	return l, nil
+}
+
+// Middleware returns a function wrapping the handlers of a downloader type, rejecting requests with a 429 once a client
+// exceeds the rate limit of the type. The handlers it wraps share the limit, so that a client can't exceed it by
+// requesting a download through each of its paths.
+func (l *RateLimiter) Middleware(downloaderType string) func(http.Handler) http.Handler {
+	rate, ok := l.cfg.RateByType[downloaderType]
+	if !ok {
+		rate = l.cfg.Rate
+	}
+	if rate <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	limits := newBuckets(rate, l.cfg.Burst, l.now)
+	limit := strconv.Itoa(l.cfg.Burst)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, exempt := l.client(r)
+			if exempt {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowed, remaining, reset := limits.take(client)
+			w.Header().Set("RateLimit-Limit", limit)
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+			if !allowed {
+				log.Warn(r.Context(), "rate limit exceeded", log.Data{"client": client, "type": downloaderType})
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Duration(float64(time.Second)/rate).Seconds()))))
+				w.Header().Set("Cache-Control", "no-store")
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// client returns the identity a request is rate limited by, and whether the client is exempt from rate limiting.
+// Requests with a configured API key are limited by the key, and other requests by the IP address of the client.
+func (l *RateLimiter) client(r *http.Request) (client string, exempt bool) {
+	if isExempt(r.Context()) {
+		return "", true
+	}
+	if l.cfg.APIKeyHeader != "" {
+		if key := r.Header.Get(l.cfg.APIKeyHeader); key != "" {
+			if l.allowedKeys[key] {
+				return "", true
+			}
+			if l.apiKeys[key] {
+				return "key:" + key, false
+			}
+		}
+	}
+
+	ip := l.clientIP(r)
+	if ip == nil {
+		return "ip:" + r.RemoteAddr, false
+	}
+	if contains(l.allowedNets, ip) {
+		return "", true
+	}
+	return "ip:" + ip.String(), false
+}
+
+// clientIP returns the IP address of the client. If the request came through trusted proxies, this is the last
+// address in X-Forwarded-For that wasn't added by a trusted proxy.
+func (l *RateLimiter) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !contains(l.trustedProxies, ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !contains(l.trustedProxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		network, err := parseNetwork(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// parseNetwork parses a network in CIDR notation, or a single IP address
+func parseNetwork(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address: %q", value)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	return network, err
+}
@@ -0,0 +1,178 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+	Convey("Given a rate limiter allowing a burst of 2 requests, refilled at 1 per second", t, func() {
+		now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		limiter, err := New(Config{
+			Rate:           1,
+			RateByType:     map[string]float64{"unlimited": 0},
+			Burst:          2,
+			TrustedProxies: []string{"10.0.0.0/8"},
+			APIKeyHeader:   "X-API-Key",
+			APIKeys:        []string{"partner", "internal"},
+			AllowList:      []string{"192.168.1.1", "internal"},
+		})
+		So(err, ShouldBeNil)
+		limiter.now = func() time.Time { return now }
+		handler := limiter.Middleware("table")(okHandler)
+
+		get := func(remoteAddr string, header map[string]string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest("GET", "/download/table", http.NoBody)
+			r.RemoteAddr = remoteAddr
+			for key, value := range header {
+				r.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("When a client exceeds the burst", func() {
+			first := get("1.2.3.4:1000", nil)
+			get("1.2.3.4:1001", nil)
+			limited := get("1.2.3.4:1002", nil)
+
+			Convey("Requests within the burst should be allowed, with the remaining limit", func() {
+				So(first.Code, ShouldEqual, http.StatusOK)
+				So(first.Header().Get("RateLimit-Limit"), ShouldEqual, "2")
+				So(first.Header().Get("RateLimit-Remaining"), ShouldEqual, "1")
+				So(first.Header().Get("RateLimit-Reset"), ShouldEqual, "1")
+			})
+
+			Convey("Further requests should be rejected with a 429", func() {
+				So(limited.Code, ShouldEqual, http.StatusTooManyRequests)
+				So(limited.Header().Get("RateLimit-Remaining"), ShouldEqual, "0")
+				So(limited.Header().Get("RateLimit-Reset"), ShouldEqual, "2")
+				So(limited.Header().Get("Retry-After"), ShouldEqual, "1")
+			})
+
+			Convey("Other clients should not be limited", func() {
+				So(get("5.6.7.8:1000", nil).Code, ShouldEqual, http.StatusOK)
+			})
+
+			Convey("The client should be allowed again once the bucket has refilled", func() {
+				now = now.Add(time.Second)
+				So(get("1.2.3.4:1003", nil).Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When a client makes requests through trusted proxies", func() {
+			get("10.0.0.1:1000", map[string]string{"X-Forwarded-For": "9.9.9.9, 1.2.3.4, 10.0.0.2"})
+			get("10.0.0.3:1000", map[string]string{"X-Forwarded-For": "8.8.8.8, 1.2.3.4"})
+			w := get("1.2.3.4:1000", nil)
+
+			Convey("It should be identified by the address before the trusted proxies", func() {
+				So(w.Code, ShouldEqual, http.StatusTooManyRequests)
+			})
+		})
+
+		Convey("When a client that isn't a trusted proxy sends X-Forwarded-For", func() {
+			get("1.2.3.4:1000", map[string]string{"X-Forwarded-For": "5.5.5.5"})
+			get("1.2.3.4:1000", map[string]string{"X-Forwarded-For": "6.6.6.6"})
+			w := get("1.2.3.4:1000", map[string]string{"X-Forwarded-For": "7.7.7.7"})
+
+			Convey("The header should be ignored", func() {
+				So(w.Code, ShouldEqual, http.StatusTooManyRequests)
+			})
+		})
+
+		Convey("When a client sends a known API key", func() {
+			get("1.2.3.4:1000", map[string]string{"X-API-Key": "partner"})
+			get("1.2.3.4:1000", map[string]string{"X-API-Key": "partner"})
+
+			Convey("It should be limited separately from its IP address", func() {
+				So(get("1.2.3.4:1000", map[string]string{"X-API-Key": "partner"}).Code, ShouldEqual, http.StatusTooManyRequests)
+				So(get("1.2.3.4:1000", map[string]string{"X-API-Key": "unknown"}).Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When allow-listed clients make many requests", func() {
+			for i := 0; i < 3; i++ {
+				get("192.168.1.1:1000", nil)
+				get("1.2.3.4:1000", map[string]string{"X-API-Key": "internal"})
+			}
+
+			Convey("They should not be limited", func() {
+				w := get("192.168.1.1:1000", nil)
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("RateLimit-Limit"), ShouldBeEmpty)
+				So(get("1.2.3.4:1000", map[string]string{"X-API-Key": "internal"}).Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When internal requests are made many times", func() {
+			internal := func() *httptest.ResponseRecorder {
+				r := httptest.NewRequest("GET", "/download/table", http.NoBody)
+				r = r.WithContext(Exempt(r.Context()))
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				return w
+			}
+			for i := 0; i < 3; i++ {
+				internal()
+			}
+
+			Convey("They should not be limited", func() {
+				So(internal().Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When a client exceeds the burst through two handlers wrapped for the same type", func() {
+			limit := limiter.Middleware("table")
+			download, checksum := limit(okHandler), limit(okHandler)
+			serve := func(handler http.Handler, path string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest("GET", path, http.NoBody)
+				r.RemoteAddr = "1.2.3.4:1000"
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				return w
+			}
+			serve(download, "/download/table")
+			serve(checksum, "/download/table.sha256")
+			w := serve(download, "/download/table")
+
+			Convey("The handlers should share the limit", func() {
+				So(w.Code, ShouldEqual, http.StatusTooManyRequests)
+			})
+		})
+
+		Convey("When a type with no rate limit is requested", func() {
+			unlimited := limiter.Middleware("unlimited")(okHandler)
+
+			Convey("The handler should not be wrapped", func() {
+				So(unlimited, ShouldEqual, okHandler)
+			})
+		})
+	})
+
+	Convey("Given an invalid trusted proxy", t, func() {
+		_, err := New(Config{TrustedProxies: []string{"not-a-network"}})
+
+		Convey("An error should be returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an allow list entry that is neither a network nor a configured API key", t, func() {
+		_, err := New(Config{APIKeys: []string{"partner"}, AllowList: []string{"192.168.1.1", "192.168.1.300"}})
+
+		Convey("An error should be returned", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "192.168.1.300")
+		})
+	})
+}
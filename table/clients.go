@@ -3,6 +3,8 @@ package table
 import (
 	"context"
 	"net/http"
+
+	"github.com/ONSdigital/dp-healthcheck/healthcheck"
 )
 
 //go:generate moq -out testdata/zebedeeclient.go -pkg testdata . ZebedeeClient
@@ -15,3 +17,8 @@ type ZebedeeClient interface {
 type RendererClient interface {
 	PostBody(ctx context.Context, format string, body []byte) (resp *http.Response, err error)
 }
+
+// checker is implemented by clients that can check the health of the service they call
+type checker interface {
+	Checker(ctx context.Context, check *healthcheck.CheckState) error
+}
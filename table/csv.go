@@ -0,0 +1,157 @@
+package table
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+)
+
+var (
+	delimiterParam   = "delimiter"
+	bomParam         = "bom"
+	lineEndingsParam = "line_endings"
+	encodingParam    = "encoding"
+)
+
+const (
+	encodingUTF8        = "utf-8"
+	encodingWindows1252 = "windows-1252"
+	utf8BOM             = "\xEF\xBB\xBF"
+)
+
+var delimiters = map[string]rune{
+	"comma":     ',',
+	"semicolon": ';',
+	"tab":       '\t',
+}
+
+// defaultCSVDialect is the dialect of the csv produced by the renderer
+var defaultCSVDialect = csvDialect{delimiter: ',', encoding: encodingUTF8}
+
+// csvDialect describes how a csv file produced by the renderer should be rewritten before being returned
+type csvDialect struct {
+	delimiter rune
+	bom       bool
+	crlf      bool
+	encoding  string
+}
+
+// parseCSVDialect reads the optional csv query parameters from the request:
+// 'delimiter' - comma (default), semicolon or tab.
+// 'bom' - true to prefix the file with a UTF-8 byte order mark.
+// 'line_endings' - lf (default) or crlf.
+// 'encoding' - utf-8 (default) or windows-1252.
+func parseCSVDialect(query url.Values) (csvDialect, error) {
+	dialect := defaultCSVDialect
+
+	if value := query.Get(delimiterParam); value != "" {
+		delimiter, ok := delimiters[value]
+		if !ok {
+			return dialect, errors.New("unsupported delimiter: " + value)
+		}
+		dialect.delimiter = delimiter
+	}
+
+	if value := query.Get(bomParam); value != "" {
+		bom, err := strconv.ParseBool(value)
+		if err != nil {
+			return dialect, errors.New("invalid bom: " + value)
+		}
+		dialect.bom = bom
+	}
+
+	switch value := query.Get(lineEndingsParam); value {
+	case "", "lf":
+	case "crlf":
+		dialect.crlf = true
+	default:
+		return dialect, errors.New("unsupported line endings: " + value)
+	}
+
+	switch value := query.Get(encodingParam); value {
+	case "", encodingUTF8:
+	case encodingWindows1252:
+		if dialect.bom {
+			return dialect, errors.New("a byte order mark can only be added to utf-8 files")
+		}
+		dialect.encoding = encodingWindows1252
+	default:
+		return dialect, errors.New("unsupported encoding: " + value)
+	}
+
+	return dialect, nil
+}
+
+// isDefault returns true if the dialect matches the csv produced by the renderer, so no rewriting is required
+func (d csvDialect) isDefault() bool {
+	return d.delimiter == ',' && !d.bom && !d.crlf && d.encoding == encodingUTF8
+}
+
+// contentType returns the Content-Type of a csv file written in this dialect
+func (d csvDialect) contentType() string {
+	return "text/csv; charset=" + d.encoding
+}
+
+// rewrite returns a reader that streams the csv from body rewritten in this dialect. The rewriting goroutine is the
+// only writer to the returned reader, and the only one to close body, once it has been fully read or the returned
+// reader is closed.
+func (d csvDialect) rewrite(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		pw.CloseWithError(d.copy(pw, body))
+	}()
+	return pr
+}
+
+// copy reads csv records from src and writes them to dst in this dialect
+func (d csvDialect) copy(dst io.Writer, src io.Reader) error {
+	var encoder *transform.Writer
+	if d.encoding == encodingWindows1252 {
+		encoder = transform.NewWriter(dst, encoding.ReplaceUnsupported(charmap.Windows1252.NewEncoder()))
+		dst = encoder
+	}
+
+	if d.bom {
+		if _, err := io.WriteString(dst, utf8BOM); err != nil {
+			return err
+		}
+	}
+
+	reader := csv.NewReader(src)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+
+	writer := csv.NewWriter(dst)
+	writer.Comma = d.delimiter
+	writer.UseCRLF = d.crlf
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err = writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	if encoder != nil {
+		return encoder.Close()
+	}
+	return nil
+}
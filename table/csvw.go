@@ -0,0 +1,228 @@
+package table
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	csvwContext           = "http://www.w3.org/ns/csvw"
+	csvwMetadataExtension = "csv-metadata.json"
+)
+
+var (
+	isoDate        = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	nonNameChars   = regexp.MustCompile(`[^a-z0-9]+`)
+	groupedInteger = regexp.MustCompile(`^-?\d{1,3}(,\d{3})+$`)
+	groupedDecimal = regexp.MustCompile(`^-?\d{1,3}(,\d{3})+\.\d+$`)
+)
+
+// csvwMetadata is a W3C CSV on the Web (CSVW) metadata document describing a single csv file
+type csvwMetadata struct {
+	Context     []interface{}   `json:"@context"`
+	URL         string          `json:"url"`
+	Title       string          `json:"dc:title,omitempty"`
+	Description string          `json:"dc:description,omitempty"`
+	Source      *csvwLink       `json:"dc:source,omitempty"`
+	Licence     *csvwLink       `json:"dc:license,omitempty"`
+	Comments    []string        `json:"rdfs:comment,omitempty"`
+	Dialect     csvwDialect     `json:"dialect"`
+	TableSchema csvwTableSchema `json:"tableSchema"`
+}
+
+type csvwLink struct {
+	ID string `json:"@id"`
+}
+
+type csvwDialect struct {
+	Delimiter      string   `json:"delimiter"`
+	Encoding       string   `json:"encoding"`
+	SkipRows       int      `json:"skipRows,omitempty"`
+	Header         bool     `json:"header"`
+	HeaderRowCount int      `json:"headerRowCount"`
+	LineTerminator []string `json:"lineTerminators"`
+}
+
+type csvwTableSchema struct {
+	Columns []csvwColumn `json:"columns"`
+}
+
+type csvwColumn struct {
+	Name     string      `json:"name"`
+	Titles   string      `json:"titles"`
+	Datatype interface{} `json:"datatype"`
+}
+
+// renderCSVWMetadata writes the CSVW metadata document describing the csv file in the bundle produced by
+// renderCSVWBundle, which has the tidy layout of that file rather than that of the csv produced by the renderer
+func (downloader *Downloader) renderCSVWMetadata(req *renderRequest, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(downloader.csvwMetadata(req))
+}
+
+// renderCSVWBundle writes a zip file containing the table as a csv file alongside its CSVW metadata document
+func (downloader *Downloader) renderCSVWBundle(req *renderRequest, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	name := baseFilename(req.uri)
+
+	csvFile, err := zw.Create(name + ".csv")
+	if err != nil {
+		return err
+	}
+	if err = writeTidyCSV(req.def, req.dialect, csvFile); err != nil {
+		return err
+	}
+
+	metadataFile, err := zw.Create(name + "." + csvwMetadataExtension)
+	if err != nil {
+		return err
+	}
+	if err = downloader.renderCSVWMetadata(req, metadataFile); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// csvwMetadata builds the CSVW metadata for the table, describing a csv file with a single header row followed by the body of the table
+func (downloader *Downloader) csvwMetadata(req *renderRequest) csvwMetadata {
+	def := req.def
+	lang := req.lang
+	if lang == "" {
+		lang = "en"
+	}
+
+	lineTerminator := "\n"
+	if req.dialect.crlf {
+		lineTerminator = "\r\n"
+	}
+
+	metadata := csvwMetadata{
+		Context:     []interface{}{csvwContext, map[string]string{"@language": lang}},
+		URL:         baseFilename(req.uri) + ".csv",
+		Title:       def.Title,
+		Description: def.Subtitle,
+		Comments:    def.Footnotes,
+		Dialect: csvwDialect{
+			Delimiter:      string(req.dialect.delimiter),
+			Encoding:       req.dialect.encoding,
+			Header:         true,
+			HeaderRowCount: 1,
+			LineTerminator: []string{lineTerminator},
+		},
+	}
+	if def.Source != "" {
+		metadata.Comments = append([]string{"Source: " + def.Source}, metadata.Comments...)
+	}
+	if def.notice != "" {
+		metadata.Comments = append([]string{def.notice}, metadata.Comments...)
+		// the notice is written above the header of the csv file
+		metadata.Dialect.SkipRows = 1
+	}
+	if downloader.siteURL != "" {
+		metadata.Source = &csvwLink{ID: downloader.sourceURL(req.uri)}
+	}
+	if downloader.licence != "" {
+		metadata.Licence = &csvwLink{ID: downloader.licence}
+	}
+
+	rows := def.rows()
+	names := make(map[string]int)
+	for col, title := range def.header() {
+		values := make([]string, 0, len(rows))
+		for _, row := range rows {
+			values = append(values, row[col])
+		}
+		metadata.TableSchema.Columns = append(metadata.TableSchema.Columns, csvwColumn{
+			Name:     columnName(title, col, names),
+			Titles:   title,
+			Datatype: inferDatatype(values),
+		})
+	}
+
+	return metadata
+}
+
+// writeTidyCSV writes the table as a csv file with a single header row followed by the body of the table, in the given
+// dialect. Any notice is written above the header.
+func writeTidyCSV(def *Definition, dialect csvDialect, w io.Writer) error {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	header := def.header()
+	if err := writeNoticeRecord(def, len(header), writer); err != nil {
+		return err
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(def.rows()); err != nil {
+		return err
+	}
+	return dialect.copy(w, &buf)
+}
+
+// columnName returns a name for the column that is unique within the table and valid in a CSVW URI template
+func columnName(title string, col int, names map[string]int) string {
+	name := strings.Trim(nonNameChars.ReplaceAllString(strings.ToLower(title), "_"), "_")
+	if name == "" {
+		name = "column_" + strconv.Itoa(col+1)
+	}
+	names[name]++
+	if count := names[name]; count > 1 {
+		name += "_" + strconv.Itoa(count)
+	}
+	return name
+}
+
+// inferDatatype returns the most specific CSVW datatype that all non-empty values in a column conform to
+func inferDatatype(values []string) interface{} {
+	integer, decimal, date, grouped := true, true, true, false
+	found := false
+
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		found = true
+
+		isGroupedInteger := groupedInteger.MatchString(value)
+		isGroupedDecimal := groupedDecimal.MatchString(value)
+		grouped = grouped || isGroupedInteger || isGroupedDecimal
+
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil && !isGroupedInteger {
+			integer = false
+		}
+		if _, err := strconv.ParseFloat(value, 64); err != nil && !isGroupedInteger && !isGroupedDecimal {
+			decimal = false
+		}
+		if !isoDate.MatchString(value) {
+			date = false
+		}
+	}
+
+	switch {
+	case !found:
+		return "string"
+	case date:
+		return "date"
+	case integer && grouped:
+		return map[string]interface{}{"base": "integer", "format": map[string]string{"groupChar": ","}}
+	case integer:
+		return "integer"
+	case decimal && grouped:
+		return map[string]interface{}{"base": "decimal", "format": map[string]string{"groupChar": ",", "decimalChar": "."}}
+	case decimal:
+		return "decimal"
+	default:
+		return "string"
+	}
+}
@@ -0,0 +1,236 @@
+package table
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Definition is the json definition of a table as stored in the content server and posted to the renderer.
+// Only the fields required to render a table in-process are modelled.
+type Definition struct {
+	Filename      string         `json:"filename"`
+	Title         string         `json:"title"`
+	Subtitle      string         `json:"subtitle"`
+	URI           string         `json:"uri"`
+	Source        string         `json:"source"`
+	ReleaseDate   string         `json:"release_date"`
+	Notes         []string       `json:"notes"`
+	Footnotes     []string       `json:"footnotes"`
+	Data          [][]string     `json:"data"`
+	RowFormats    []RowFormat    `json:"row_formats"`
+	ColumnFormats []ColumnFormat `json:"column_formats"`
+	CellFormats   []CellFormat   `json:"cell_formats"`
+	// Banner is the text of the banner row added above the data by addBannerRow. It is not part of a stored definition.
+	Banner string `json:"banner,omitempty"`
+
+	// notice is displayed prominently in the rendered table, e.g. to mark unpublished content
+	notice string
+}
+
+// RowFormat describes formatting applied to a whole row of the table
+type RowFormat struct {
+	Row     int  `json:"row"`
+	Heading bool `json:"heading"`
+}
+
+// ColumnFormat describes formatting applied to a whole column of the table
+type ColumnFormat struct {
+	Column  int    `json:"col"`
+	Align   string `json:"align"`
+	Heading bool   `json:"heading"`
+}
+
+// CellFormat describes formatting applied to a single cell, including merged cells
+type CellFormat struct {
+	Row     int `json:"row"`
+	Column  int `json:"col"`
+	Rowspan int `json:"rowspan"`
+	Colspan int `json:"colspan"`
+}
+
+// parseDefinition unmarshals the json definition of a table. A banner row is removed from the data and becomes the
+// notice of the table, for renderers to display in the way that suits their format.
+func parseDefinition(b []byte) (*Definition, error) {
+	var def Definition
+	if err := json.Unmarshal(b, &def); err != nil {
+		return nil, err
+	}
+	if def.Banner != "" && len(def.Data) > 1 && len(def.Data[0]) > 0 && def.Data[0][0] == def.Banner {
+		def.removeBannerRow()
+	}
+	if len(def.Data) == 0 {
+		return nil, errors.New("table definition contains no data")
+	}
+	return &def, nil
+}
+
+// removeBannerRow reverses addBannerRow, moving the banner into the notice and restoring the rows of the formats
+func (def *Definition) removeBannerRow() {
+	def.notice = def.Banner
+	def.Data = def.Data[1:]
+
+	rowFormats := make([]RowFormat, 0, len(def.RowFormats))
+	for _, f := range def.RowFormats {
+		if f.Row > 0 {
+			f.Row--
+			rowFormats = append(rowFormats, f)
+		}
+	}
+	def.RowFormats = rowFormats
+
+	cellFormats := make([]CellFormat, 0, len(def.CellFormats))
+	for _, f := range def.CellFormats {
+		if f.Row > 0 {
+			f.Row--
+			cellFormats = append(cellFormats, f)
+		}
+	}
+	def.CellFormats = cellFormats
+}
+
+// columnCount returns the width of the widest row in the table
+func (def *Definition) columnCount() int {
+	count := 0
+	for _, row := range def.Data {
+		if len(row) > count {
+			count = len(row)
+		}
+	}
+	return count
+}
+
+// headerRowCount returns the number of leading rows marked as headings.
+// If no rows are marked as headings, the first row of a table with more than one row is treated as the header.
+func (def *Definition) headerRowCount() int {
+	headings := make(map[int]bool)
+	for _, f := range def.RowFormats {
+		if f.Heading {
+			headings[f.Row] = true
+		}
+	}
+	count := 0
+	for headings[count] && count < len(def.Data) {
+		count++
+	}
+	if count == 0 && len(def.Data) > 1 {
+		return 1
+	}
+	return count
+}
+
+// cell returns the value of the cell at the given position, or an empty string if the row is short
+func (def *Definition) cell(row, col int) string {
+	if row < 0 || row >= len(def.Data) || col < 0 || col >= len(def.Data[row]) {
+		return ""
+	}
+	return def.Data[row][col]
+}
+
+// expandedCell returns the value of the cell at the given position, using the value of the merged cell covering it if there is one
+func (def *Definition) expandedCell(row, col int) string {
+	for _, f := range def.CellFormats {
+		if row >= f.Row && row < f.Row+max(f.Rowspan, 1) && col >= f.Column && col < f.Column+max(f.Colspan, 1) {
+			return def.cell(f.Row, f.Column)
+		}
+	}
+	return def.cell(row, col)
+}
+
+// header returns a single header value for each column, combining the values of all header rows
+func (def *Definition) header() []string {
+	headerRows := def.headerRowCount()
+	header := make([]string, def.columnCount())
+	for col := range header {
+		var parts []string
+		for row := 0; row < headerRows; row++ {
+			value := strings.TrimSpace(def.expandedCell(row, col))
+			if value != "" && (len(parts) == 0 || parts[len(parts)-1] != value) {
+				parts = append(parts, value)
+			}
+		}
+		header[col] = strings.Join(parts, " - ")
+	}
+	return header
+}
+
+// rows returns the body of the table, padded to the full width of the table
+func (def *Definition) rows() [][]string {
+	width := def.columnCount()
+	body := def.Data[def.headerRowCount():]
+	rows := make([][]string, len(body))
+	for i, row := range body {
+		rows[i] = make([]string, width)
+		copy(rows[i], row)
+	}
+	return rows
+}
+
+// columnAlign returns the alignment of the given column, if one is defined
+func (def *Definition) columnAlign(col int) string {
+	for _, f := range def.ColumnFormats {
+		if f.Column == col {
+			return f.Align
+		}
+	}
+	return ""
+}
+
+// columnHeading returns true if the given column is marked as holding the headings of the rows
+func (def *Definition) columnHeading(col int) bool {
+	for _, f := range def.ColumnFormats {
+		if f.Column == col {
+			return f.Heading
+		}
+	}
+	return false
+}
+
+// mergedCell returns the format of the merged cell originating at the given position, if there is one
+func (def *Definition) mergedCell(row, col int) (CellFormat, bool) {
+	for _, f := range def.CellFormats {
+		if f.Row == row && f.Column == col && (f.Rowspan > 1 || f.Colspan > 1) {
+			return f, true
+		}
+	}
+	return CellFormat{}, false
+}
+
+// coveredCells returns the positions of the cells hidden beneath merged cells, excluding the cell each merge originates at
+func coveredCells(def *Definition) map[[2]int]bool {
+	covered := make(map[[2]int]bool)
+	for _, f := range def.CellFormats {
+		for _, cell := range f.cells()[1:] {
+			covered[cell] = true
+		}
+	}
+	return covered
+}
+
+// merged reports whether the format merges its cell with those beside or below it
+func (f CellFormat) merged() bool {
+	return f.Rowspan > 1 || f.Colspan > 1
+}
+
+// cells returns the positions of the cells the format spans, starting with the cell it originates at
+func (f CellFormat) cells() [][2]int {
+	rows, cols := max(f.Rowspan, 1), max(f.Colspan, 1)
+	cells := make([][2]int, 0, rows*cols)
+	for row := f.Row; row < f.Row+rows; row++ {
+		for col := f.Column; col < f.Column+cols; col++ {
+			cells = append(cells, [2]int{row, col})
+		}
+	}
+	return cells
+}
+
+// parseNumber returns the numeric value of a cell, ignoring thousands separators
+func parseNumber(value string) (float64, bool) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), ",", "")
+	if value == "" {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	return f, err == nil
+}
@@ -0,0 +1,75 @@
+package table
+
+import (
+	"context"
+	"io"
+)
+
+// format describes a file format that a table can be downloaded in
+type format struct {
+	// name is the value of the format query parameter that selects this format
+	name string
+	// extension is the file extension given to the downloaded file
+	extension string
+	// contentType is the Content-Type of the downloaded file
+	contentType string
+	// csv is true if the file is, or contains, a csv file written in the dialect of the csv query parameters
+	csv bool
+	// metadata is true if the file is a spreadsheet that can include a worksheet describing the provenance of the table
+	metadata bool
+	// render produces the file in-process from the table definition. If nil, the file is produced by the renderer service.
+	render func(downloader *Downloader, req *renderRequest, w io.Writer) error
+}
+
+// renderRequest holds everything known about a request to render a table in-process
+type renderRequest struct {
+	ctx     context.Context
+	def     *Definition
+	uri     string
+	lang    string
+	dialect csvDialect
+	// metadata is true if a worksheet describing the provenance of the table is requested
+	metadata bool
+	// preview is true when the table is unpublished content from a collection
+	preview bool
+}
+
+// parseDefinition parses the json definition of the table
+func (req *renderRequest) parseDefinition(definition []byte) error {
+	def, err := parseDefinition(definition)
+	if err != nil {
+		return err
+	}
+	req.def = def
+	return nil
+}
+
+// formats is the registry of all formats that tables can be downloaded in, keyed by name
+var formats = map[string]format{
+	"html":     {name: "html", extension: "html", contentType: "text/html; charset=utf-8"},
+	"xlsx":     {name: "xlsx", extension: "xlsx", contentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", metadata: true},
+	"csv":      {name: "csv", extension: "csv", contentType: "text/csv; charset=utf-8", csv: true},
+	"json":     {name: "json", extension: "json", contentType: "application/json", render: definitionRenderer(renderJSON)},
+	"md":       {name: "md", extension: "md", contentType: "text/markdown; charset=utf-8", render: definitionRenderer(renderMarkdown)},
+	"ods":      {name: "ods", extension: "ods", contentType: "application/vnd.oasis.opendocument.spreadsheet", metadata: true, render: (*Downloader).renderODS},
+	"csvw":     {name: "csvw", extension: csvwMetadataExtension, contentType: "application/csvm+json", csv: true, render: (*Downloader).renderCSVWMetadata},
+	"csvw-zip": {name: "csvw-zip", extension: "zip", contentType: "application/zip", csv: true, render: (*Downloader).renderCSVWBundle},
+}
+
+// definitionRenderer adapts a function that renders a table using only its definition for use in the registry
+func definitionRenderer(render func(def *Definition, w io.Writer) error) func(*Downloader, *renderRequest, io.Writer) error {
+	return func(_ *Downloader, req *renderRequest, w io.Writer) error {
+		return render(req.def, w)
+	}
+}
+
+// lookupFormat returns the registered format with the given name
+func lookupFormat(name string) (format, bool) {
+	f, ok := formats[name]
+	return f, ok
+}
+
+// isLocal returns true if the format is rendered in-process rather than by the renderer service
+func (f format) isLocal() bool {
+	return f.render != nil
+}
@@ -0,0 +1,33 @@
+package table
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonTable is the machine-friendly json representation of a table
+type jsonTable struct {
+	Notice    string     `json:"notice,omitempty"`
+	Title     string     `json:"title,omitempty"`
+	Subtitle  string     `json:"subtitle,omitempty"`
+	Source    string     `json:"source,omitempty"`
+	Header    []string   `json:"header"`
+	Rows      [][]string `json:"rows"`
+	Footnotes []string   `json:"footnotes,omitempty"`
+}
+
+// renderJSON writes the table as a json document containing a header array and an array of rows
+func renderJSON(def *Definition, w io.Writer) error {
+	t := jsonTable{
+		Notice:    def.notice,
+		Title:     def.Title,
+		Subtitle:  def.Subtitle,
+		Source:    def.Source,
+		Header:    def.header(),
+		Rows:      def.rows(),
+		Footnotes: def.Footnotes,
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(t)
+}
@@ -0,0 +1,165 @@
+package table
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+)
+
+// LocalRenderer is an in-process RendererClient, for rendering tables without the renderer service.
+// It renders html and csv; other formats of the renderer service, such as xlsx, are not supported.
+type LocalRenderer struct{}
+
+var _ RendererClient = LocalRenderer{}
+
+// localRenderers are the formats of the renderer service that LocalRenderer can produce, keyed by name
+var localRenderers = map[string]func(def *Definition, w io.Writer) error{
+	"html": renderHTML,
+	"csv":  renderCSV,
+}
+
+// PostBody renders the json definition of the table in the given format, responding as the renderer service would
+func (LocalRenderer) PostBody(ctx context.Context, format string, body []byte) (*http.Response, error) {
+	render, ok := localRenderers[format]
+	if !ok {
+		return nil, fmt.Errorf("format %s can only be rendered by the renderer service", format)
+	}
+
+	def, err := parseDefinition(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := render(def, &buf); err != nil {
+		return nil, err
+	}
+
+	f, _ := lookupFormat(format)
+	header := http.Header{}
+	header.Set("Content-Type", f.contentType)
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          io.NopCloser(&buf),
+		ContentLength: int64(buf.Len()),
+	}, nil
+}
+
+// renderCSV writes every row of the table as a csv record, repeating the values of merged cells. Any notice is
+// written as the first record, as the renderer service writes a banner row.
+func renderCSV(def *Definition, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	width := def.columnCount()
+	if err := writeNoticeRecord(def, width, writer); err != nil {
+		return err
+	}
+	for row := range def.Data {
+		record := make([]string, width)
+		for col := range record {
+			record[col] = def.expandedCell(row, col)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeNoticeRecord writes the notice of the table as a record as wide as the table, if it has one
+func writeNoticeRecord(def *Definition, width int, writer *csv.Writer) error {
+	if def.notice == "" {
+		return nil
+	}
+	record := make([]string, max(width, 1))
+	record[0] = def.notice
+	return writer.Write(record)
+}
+
+// renderHTML writes the table as an html fragment, with the title as its caption and followed by any notes and footnotes
+func renderHTML(def *Definition, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	bw.WriteString("<div class=\"table\">\n")
+	if def.notice != "" {
+		fmt.Fprintf(bw, "<p class=\"notice\"><strong>%s</strong></p>\n", html.EscapeString(def.notice))
+	}
+	bw.WriteString("<table>\n")
+	if def.Title != "" || def.Subtitle != "" {
+		fmt.Fprintf(bw, "<caption>%s", html.EscapeString(def.Title))
+		if def.Subtitle != "" {
+			fmt.Fprintf(bw, "<br><span class=\"subtitle\">%s</span>", html.EscapeString(def.Subtitle))
+		}
+		bw.WriteString("</caption>\n")
+	}
+
+	headerRows := def.headerRowCount()
+	covered := coveredCells(def)
+	for row := range def.Data {
+		switch row {
+		case 0:
+			if headerRows > 0 {
+				bw.WriteString("<thead>\n")
+			} else {
+				bw.WriteString("<tbody>\n")
+			}
+		case headerRows:
+			bw.WriteString("</thead>\n<tbody>\n")
+		}
+
+		bw.WriteString("<tr>")
+		for col := 0; col < def.columnCount(); col++ {
+			if covered[[2]int{row, col}] {
+				continue
+			}
+			tag, attributes := "td", ""
+			if row < headerRows {
+				tag, attributes = "th", " scope=\"col\""
+			} else if def.columnHeading(col) {
+				tag, attributes = "th", " scope=\"row\""
+			}
+			if f, ok := def.mergedCell(row, col); ok {
+				if f.Rowspan > 1 {
+					attributes += fmt.Sprintf(" rowspan=\"%d\"", f.Rowspan)
+				}
+				if f.Colspan > 1 {
+					attributes += fmt.Sprintf(" colspan=\"%d\"", f.Colspan)
+				}
+			}
+			if align := def.columnAlign(col); align != "" {
+				attributes += fmt.Sprintf(" class=\"align-%s\"", html.EscapeString(align))
+			}
+			fmt.Fprintf(bw, "<%s%s>%s</%s>", tag, attributes, html.EscapeString(def.cell(row, col)), tag)
+		}
+		bw.WriteString("</tr>\n")
+	}
+	if headerRows >= len(def.Data) {
+		bw.WriteString("</thead>\n")
+	} else {
+		bw.WriteString("</tbody>\n")
+	}
+	bw.WriteString("</table>\n")
+
+	if def.Source != "" {
+		fmt.Fprintf(bw, "<p class=\"source\">Source: %s</p>\n", html.EscapeString(def.Source))
+	}
+	for _, note := range def.Notes {
+		fmt.Fprintf(bw, "<p class=\"note\">%s</p>\n", html.EscapeString(note))
+	}
+	if len(def.Footnotes) > 0 {
+		bw.WriteString("<ol class=\"footnotes\">\n")
+		for _, footnote := range def.Footnotes {
+			fmt.Fprintf(bw, "<li>%s</li>\n", html.EscapeString(footnote))
+		}
+		bw.WriteString("</ol>\n")
+	}
+	bw.WriteString("</div>\n")
+
+	return bw.Flush()
+}
@@ -0,0 +1,68 @@
+package table
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var markdownEscaper = strings.NewReplacer("|", "\\|", "\r\n", "<br>", "\n", "<br>")
+
+// renderMarkdown writes the table as a GitHub-flavoured markdown table, preceded by the title and followed by any footnotes
+func renderMarkdown(def *Definition, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	if def.notice != "" {
+		fmt.Fprintf(bw, "> **%s**\n\n", markdownEscaper.Replace(def.notice))
+	}
+	if def.Title != "" {
+		fmt.Fprintf(bw, "## %s\n\n", markdownEscaper.Replace(def.Title))
+	}
+	if def.Subtitle != "" {
+		fmt.Fprintf(bw, "%s\n\n", markdownEscaper.Replace(def.Subtitle))
+	}
+
+	header := def.header()
+	writeMarkdownRow(bw, header)
+
+	delimiters := make([]string, len(header))
+	for col := range delimiters {
+		switch def.columnAlign(col) {
+		case "left":
+			delimiters[col] = ":---"
+		case "right":
+			delimiters[col] = "---:"
+		case "center", "centre":
+			delimiters[col] = ":---:"
+		default:
+			delimiters[col] = "---"
+		}
+	}
+	fmt.Fprintf(bw, "| %s |\n", strings.Join(delimiters, " | "))
+
+	for _, row := range def.rows() {
+		writeMarkdownRow(bw, row)
+	}
+
+	if def.Source != "" {
+		fmt.Fprintf(bw, "\nSource: %s\n", markdownEscaper.Replace(def.Source))
+	}
+	if len(def.Footnotes) > 0 {
+		fmt.Fprintln(bw)
+		for i, footnote := range def.Footnotes {
+			fmt.Fprintf(bw, "%d. %s\n", i+1, markdownEscaper.Replace(footnote))
+		}
+	}
+
+	return bw.Flush()
+}
+
+// writeMarkdownRow writes a single row of a markdown table
+func writeMarkdownRow(w io.Writer, cells []string) {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		escaped[i] = markdownEscaper.Replace(strings.TrimSpace(cell))
+	}
+	fmt.Fprintf(w, "| %s |\n", strings.Join(escaped, " | "))
+}
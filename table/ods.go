@@ -0,0 +1,171 @@
+package table
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const odsMimeType = "application/vnd.oasis.opendocument.spreadsheet"
+
+const odsManifest = `<?xml version="1.0" encoding="UTF-8"?>
+<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" manifest:version="1.2">
+ <manifest:file-entry manifest:full-path="/" manifest:version="1.2" manifest:media-type="` + odsMimeType + `"/>
+ <manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml"/>
+</manifest:manifest>
+`
+
+const odsContentHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0"` +
+	` xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0"` +
+	` xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" office:version="1.2">
+<office:body><office:spreadsheet>`
+
+const odsContentFooter = `</office:spreadsheet></office:body></office:document-content>
+`
+
+// renderODS writes the table as an OpenDocument Spreadsheet, with any notice and the title above the grid and the source and footnotes below it.
+// A metadata sheet describing the provenance of the table follows it, if requested.
+func (downloader *Downloader) renderODS(req *renderRequest, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	// the mimetype must be the first entry in the archive and must not be compressed
+	mimetype, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(mimetype, odsMimeType); err != nil {
+		return err
+	}
+
+	manifest, err := zw.Create("META-INF/manifest.xml")
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(manifest, odsManifest); err != nil {
+		return err
+	}
+
+	content, err := zw.Create("content.xml")
+	if err != nil {
+		return err
+	}
+	var metadata [][2]string
+	if req.metadata {
+		metadata = downloader.metadataRows(req)
+	}
+	if err = writeODSContent(req.def, metadata, content); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// writeODSContent writes content.xml, containing a sheet with the table, and a sheet of the label/value pairs of its
+// metadata if there are any
+func writeODSContent(def *Definition, metadata [][2]string, w io.Writer) error {
+	ew := &errWriter{w: w}
+
+	ew.writeString(odsContentHeader)
+	ew.writeString(`<table:table table:name="` + xmlEscape(sheetName(def)) + `">`)
+
+	width := def.columnCount()
+	ew.writeString(fmt.Sprintf(`<table:table-column table:number-columns-repeated="%d"/>`, max(width, 1)))
+
+	if def.notice != "" {
+		ew.writeString("<table:table-row>" + odsCell(def.notice, "") + "</table:table-row>")
+	}
+	if def.Title != "" {
+		ew.writeString("<table:table-row>" + odsCell(def.Title, "") + "</table:table-row><table:table-row/>")
+	}
+
+	covered := coveredCells(def)
+	for row := range def.Data {
+		ew.writeString("<table:table-row>")
+		for col := 0; col < width; col++ {
+			if covered[[2]int{row, col}] {
+				ew.writeString("<table:covered-table-cell/>")
+				continue
+			}
+			spans := ""
+			if f, ok := def.mergedCell(row, col); ok {
+				spans = fmt.Sprintf(` table:number-columns-spanned="%d" table:number-rows-spanned="%d"`, max(f.Colspan, 1), max(f.Rowspan, 1))
+			}
+			ew.writeString(odsCell(def.cell(row, col), spans))
+		}
+		ew.writeString("</table:table-row>")
+	}
+
+	notes := []string{}
+	if def.Source != "" {
+		notes = append(notes, "Source: "+def.Source)
+	}
+	notes = append(notes, def.Footnotes...)
+	if len(notes) > 0 {
+		ew.writeString("<table:table-row/>")
+	}
+	for _, note := range notes {
+		ew.writeString("<table:table-row>" + odsCell(note, "") + "</table:table-row>")
+	}
+
+	ew.writeString("</table:table>")
+
+	if len(metadata) > 0 {
+		ew.writeString(`<table:table table:name="` + metadataSheetName + `"><table:table-column table:number-columns-repeated="2"/>`)
+		for _, row := range metadata {
+			ew.writeString("<table:table-row>" + odsCell(row[0], "") + odsCell(row[1], "") + "</table:table-row>")
+		}
+		ew.writeString("</table:table>")
+	}
+	ew.writeString(odsContentFooter)
+	return ew.err
+}
+
+// odsCell returns the xml for a single cell, typed as a number where the value is numeric
+func odsCell(value, attributes string) string {
+	if value == "" {
+		return "<table:table-cell" + attributes + "/>"
+	}
+	if f, ok := parseNumber(value); ok {
+		attributes += ` office:value-type="float" office:value="` + strconv.FormatFloat(f, 'f', -1, 64) + `"`
+	} else {
+		attributes += ` office:value-type="string"`
+	}
+	return "<table:table-cell" + attributes + "><text:p>" + xmlEscape(value) + "</text:p></table:table-cell>"
+}
+
+// sheetName returns the name to give the worksheet containing the table
+func sheetName(def *Definition) string {
+	name := def.Filename
+	if name == "" {
+		name = "Table"
+	}
+	// spreadsheet applications limit sheet names to 31 characters
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	return name
+}
+
+// xmlEscape returns the value escaped for use as xml character data or an attribute value
+func xmlEscape(value string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
+
+// errWriter writes strings to a writer, retaining the first error that occurs
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) writeString(s string) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = io.WriteString(ew.w, s)
+}
@@ -0,0 +1,66 @@
+package table
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// pathVar is the variable of the path-style route, holding the uri of the table with the extension of the format
+// in place of .json, e.g. economy/inflation/mytable.csv for /download/table/economy/inflation/mytable.csv
+const pathVar = "path"
+
+// Paths returns the template of the path-style form of download URLs, which are routed to the Downloader along with
+// the query form. A table is downloaded at the path of its uri with the extension of the format, e.g.
+// /download/table/economy/mytable.csv is /download/table?format=csv&uri=/economy/mytable.json. The path form is
+// advertised as canonical.
+func (downloader *Downloader) Paths() []string {
+	return []string{"/{" + pathVar + ":.+}"}
+}
+
+// ResolvePath returns the format and uri parameters of a path-style download
+func (downloader *Downloader) ResolvePath(vars map[string]string) (url.Values, error) {
+	path := strings.TrimPrefix(vars[pathVar], "/")
+	for _, f := range formatsByExtension() {
+		base, ok := strings.CutSuffix(path, "."+f.extension)
+		if ok && base != "" {
+			return url.Values{formatParam: {f.name}, uriParam: {"/" + base + ".json"}}, nil
+		}
+	}
+	return nil, fmt.Errorf("no format has the extension of %s", path)
+}
+
+// CanonicalPath returns the path-style form of a download, and the parameters other than its format and uri, or false
+// if the uri of the table is not a json file
+func (downloader *Downloader) CanonicalPath(params url.Values) (string, url.Values, bool) {
+	f, ok := lookupFormat(params.Get(formatParam))
+	base, isJSON := strings.CutSuffix(params.Get(uriParam), ".json")
+	if !ok || !isJSON || strings.Trim(base, "/") == "" {
+		return "", nil, false
+	}
+
+	query := make(url.Values, len(params))
+	for name, values := range params {
+		if name != formatParam && name != uriParam {
+			query[name] = values
+		}
+	}
+	return strings.TrimPrefix(base, "/") + "." + f.extension, query, true
+}
+
+// formatsByExtension returns the formats with the longest extensions first, so that an extension is matched before any
+// shorter extension it ends with
+func formatsByExtension() []format {
+	sorted := make([]format, 0, len(formats))
+	for _, f := range formats {
+		sorted = append(sorted, f)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i].extension) != len(sorted[j].extension) {
+			return len(sorted[i].extension) > len(sorted[j].extension)
+		}
+		return sorted[i].name < sorted[j].name
+	})
+	return sorted
+}
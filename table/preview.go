@@ -0,0 +1,93 @@
+package table
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// ProvisionalBanner marks files rendered from unpublished content in a collection
+const ProvisionalBanner = "PROVISIONAL – NOT FOR PUBLICATION"
+
+// bannerField records the banner row added to a definition, which the renderer service ignores
+const bannerField = "banner"
+
+// PreviewHeader is set on responses containing unpublished content from a collection
+const PreviewHeader = "X-Content-Preview"
+
+// setPreviewHeaders marks the response as containing unpublished content that must not be cached
+func setPreviewHeaders(headers map[string]string) {
+	headers[PreviewHeader] = "true"
+	headers["Cache-Control"] = "private, no-store"
+}
+
+// addBannerRow returns a copy of the json definition of a table with a banner row inserted above the data, spanning all columns.
+// The row indices of row and cell formats are shifted to account for the new row. Fields not modelled by Definition are preserved.
+// It is the only place a banner is added, so that files are marked alike by every renderer: the renderer service draws
+// the row, and tables rendered in-process are given it as their notice by parseDefinition.
+func addBannerRow(definition []byte, banner string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(definition))
+	decoder.UseNumber()
+
+	var def map[string]interface{}
+	if err := decoder.Decode(&def); err != nil {
+		return nil, err
+	}
+
+	data, ok := def["data"].([]interface{})
+	if !ok || len(data) == 0 {
+		return nil, errors.New("table definition contains no data")
+	}
+
+	width := 1
+	for _, row := range data {
+		if cells, isArray := row.([]interface{}); isArray && len(cells) > width {
+			width = len(cells)
+		}
+	}
+
+	bannerRow := make([]interface{}, width)
+	bannerRow[0] = banner
+	for i := 1; i < width; i++ {
+		bannerRow[i] = ""
+	}
+	def["data"] = append([]interface{}{bannerRow}, data...)
+	def[bannerField] = banner
+
+	for _, key := range []string{"row_formats", "cell_formats"} {
+		if err := shiftRows(def, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if width > 1 {
+		cellFormats, _ := def["cell_formats"].([]interface{})
+		def["cell_formats"] = append(cellFormats, map[string]interface{}{"row": 0, "col": 0, "colspan": width, "rowspan": 1})
+	}
+
+	return json.Marshal(def)
+}
+
+// shiftRows increments the row index of every format in the list with the given key
+func shiftRows(def map[string]interface{}, key string) error {
+	list, ok := def[key].([]interface{})
+	if !ok {
+		return nil
+	}
+	for _, item := range list {
+		f, isObject := item.(map[string]interface{})
+		if !isObject {
+			continue
+		}
+		row, isNumber := f["row"].(json.Number)
+		if !isNumber {
+			continue
+		}
+		n, err := row.Int64()
+		if err != nil {
+			return errors.New("invalid row in " + key + ": " + row.String())
+		}
+		f["row"] = n + 1
+	}
+	return nil
+}
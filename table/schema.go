@@ -0,0 +1,234 @@
+package table
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultSchemaVersion is the version of the schema that table definitions are validated against, unless they declare
+// another in their schema_version field
+const DefaultSchemaVersion = "1"
+
+// Violation is a way in which a table definition does not conform to its schema
+type Violation struct {
+	// Field is the path of the offending field, e.g. data[2] or cell_formats[0].rowspan. It is empty for the whole definition.
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Field == "" {
+		return v.Message
+	}
+	return v.Field + ": " + v.Message
+}
+
+// ValidationError is returned when a table definition does not conform to its schema, listing every violation
+type ValidationError struct {
+	SchemaVersion string      `json:"schema_version"`
+	Violations    []Violation `json:"violations"`
+}
+
+// Error lists the violations, one per line
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "table definition does not conform to schema version %s:", e.SchemaVersion)
+	for _, v := range e.Violations {
+		sb.WriteString("\n- " + v.String())
+	}
+	return sb.String()
+}
+
+// schemas are the validators of each version of the table definition schema, keyed by version
+var schemas = map[string]func(fields map[string]json.RawMessage) []Violation{
+	"1": validateV1,
+}
+
+// SchemaVersions returns the versions of the table definition schema that definitions can be validated against
+func SchemaVersions() []string {
+	versions := make([]string, 0, len(schemas))
+	for version := range schemas {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// Validate checks the json definition of a table against the version of the schema it declares, or
+// DefaultSchemaVersion. A *ValidationError is returned if it does not conform.
+func Validate(definition []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(definition, &fields); err != nil || fields == nil {
+		return &ValidationError{
+			SchemaVersion: DefaultSchemaVersion,
+			Violations:    []Violation{{Message: "definition must be a json object"}},
+		}
+	}
+
+	version := DefaultSchemaVersion
+	if raw, ok := fields["schema_version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return &ValidationError{
+				SchemaVersion: DefaultSchemaVersion,
+				Violations:    []Violation{{Field: "schema_version", Message: "must be a string"}},
+			}
+		}
+	}
+	validate, ok := schemas[version]
+	if !ok {
+		return &ValidationError{
+			SchemaVersion: version,
+			Violations: []Violation{{Field: "schema_version", Message: fmt.Sprintf(
+				"unsupported version %q, must be one of %s", version, strings.Join(SchemaVersions(), ", "))}},
+		}
+	}
+
+	if violations := validate(fields); len(violations) > 0 {
+		return &ValidationError{SchemaVersion: version, Violations: violations}
+	}
+	return nil
+}
+
+// validateV1 validates a definition against version 1 of the schema: a filename and a rectangular table of string
+// cells are required, and all row, column and cell formats must lie within the table without merged cells overlapping
+func validateV1(fields map[string]json.RawMessage) []Violation {
+	var violations []Violation
+	violate := func(field, format string, args ...interface{}) {
+		violations = append(violations, Violation{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, field := range []string{"filename", "data"} {
+		if _, ok := fields[field]; !ok {
+			violate(field, "is required")
+		}
+	}
+
+	for _, field := range []string{"filename", "title", "subtitle", "uri", "source", "release_date"} {
+		var value string
+		if raw, ok := fields[field]; ok && json.Unmarshal(raw, &value) != nil {
+			violate(field, "must be a string")
+		}
+	}
+	if raw, ok := fields["filename"]; ok {
+		var filename string
+		if json.Unmarshal(raw, &filename) == nil && strings.TrimSpace(filename) == "" {
+			violate("filename", "must not be empty")
+		}
+	}
+
+	for _, field := range []string{"notes", "footnotes"} {
+		var values []string
+		if raw, ok := fields[field]; ok && json.Unmarshal(raw, &values) != nil {
+			violate(field, "must be an array of strings")
+		}
+	}
+
+	var data [][]string
+	if raw, ok := fields["data"]; ok {
+		var rows []json.RawMessage
+		if json.Unmarshal(raw, &rows) != nil {
+			violate("data", "must be an array of rows")
+		} else if len(rows) == 0 {
+			violate("data", "must contain at least one row")
+		}
+		for i, rawRow := range rows {
+			var cells []json.RawMessage
+			if json.Unmarshal(rawRow, &cells) != nil {
+				violate(fmt.Sprintf("data[%d]", i), "must be an array of cells")
+				continue
+			}
+			row := make([]string, len(cells))
+			for j, cell := range cells {
+				if json.Unmarshal(cell, &row[j]) != nil {
+					violate(fmt.Sprintf("data[%d][%d]", i, j), "must be a string")
+				}
+			}
+			data = append(data, row)
+		}
+	}
+	if len(violations) > 0 {
+		// the remaining checks are meaningless for a definition with missing or mistyped fields
+		return violations
+	}
+
+	def := &Definition{Data: data}
+	width := def.columnCount()
+	for i, row := range data {
+		if len(row) != width {
+			violate(fmt.Sprintf("data[%d]", i), "has %d cells, but the table is %d columns wide", len(row), width)
+		}
+	}
+
+	if raw, ok := fields["row_formats"]; ok {
+		if json.Unmarshal(raw, &def.RowFormats) != nil {
+			violate("row_formats", "must be an array of objects with an integer row and boolean heading")
+		}
+		for i, f := range def.RowFormats {
+			if f.Row < 0 || f.Row >= len(data) {
+				violate(fmt.Sprintf("row_formats[%d].row", i), "refers to row %d, outside the table", f.Row)
+			}
+		}
+	}
+
+	if raw, ok := fields["column_formats"]; ok {
+		if json.Unmarshal(raw, &def.ColumnFormats) != nil {
+			violate("column_formats", "must be an array of objects with an integer col, string align and boolean heading")
+		}
+		for i, f := range def.ColumnFormats {
+			if f.Column < 0 || f.Column >= width {
+				violate(fmt.Sprintf("column_formats[%d].col", i), "refers to column %d, outside the table", f.Column)
+			}
+			if !alignments[f.Align] {
+				violate(fmt.Sprintf("column_formats[%d].align", i), "unsupported alignment %q", f.Align)
+			}
+		}
+	}
+
+	if raw, ok := fields["cell_formats"]; ok {
+		if json.Unmarshal(raw, &def.CellFormats) != nil {
+			violate("cell_formats", "must be an array of objects with integer row, col, rowspan and colspan")
+		}
+		violations = append(violations, validateMergedCells(def)...)
+	}
+
+	return violations
+}
+
+var alignments = map[string]bool{"": true, "left": true, "right": true, "center": true, "centre": true, "justify": true}
+
+// validateMergedCells checks that every cell format lies within the table, and that no merged cells overlap. Formats
+// that only style a cell, without merging it, may share it with other formats or lie within a merged cell.
+func validateMergedCells(def *Definition) []Violation {
+	var violations []Violation
+	width := def.columnCount()
+	covered := make(map[[2]int]int)
+	for i, f := range def.CellFormats {
+		field := fmt.Sprintf("cell_formats[%d]", i)
+		if f.Row < 0 || f.Row >= len(def.Data) || f.Column < 0 || f.Column >= width {
+			violations = append(violations, Violation{Field: field, Message: fmt.Sprintf("refers to cell (%d, %d), outside the table", f.Row, f.Column)})
+			continue
+		}
+		if f.Rowspan < 0 || f.Colspan < 0 {
+			violations = append(violations, Violation{Field: field, Message: "rowspan and colspan must not be negative"})
+			continue
+		}
+		lastRow, lastCol := f.Row+max(f.Rowspan, 1)-1, f.Column+max(f.Colspan, 1)-1
+		if lastRow >= len(def.Data) || lastCol >= width {
+			violations = append(violations, Violation{Field: field, Message: fmt.Sprintf("merged cell (%d, %d) extends beyond the table", f.Row, f.Column)})
+			continue
+		}
+		if !f.merged() {
+			continue
+		}
+		for _, cell := range f.cells() {
+			if other, ok := covered[cell]; ok {
+				violations = append(violations, Violation{Field: field, Message: fmt.Sprintf("overlaps the merged cell of cell_formats[%d]", other)})
+				break
+			}
+			covered[cell] = i
+		}
+	}
+	return violations
+}
@@ -1,13 +1,19 @@
 package table
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ONSdigital/dp-api-clients-go/v2/zebedee"
+	"github.com/ONSdigital/dp-healthcheck/healthcheck"
 	dphandlers "github.com/ONSdigital/dp-net/v3/handlers"
 	"github.com/ONSdigital/dp-net/v3/request"
 	"github.com/ONSdigital/log.go/v2/log"
@@ -18,18 +24,62 @@ var (
 	uriParam    = "uri"
 )
 
-// Downloader implements api.Downloader.
+// Names of the health checks of the services a Downloader depends on, unless overridden with WithContentCheckName
+const (
+	RendererCheckName       = "frontend renderer"
+	DefaultContentCheckName = "API router"
+)
+
+// DefaultLicence is the licence under which tables are published, unless overridden with WithLicence
+const DefaultLicence = "http://www.nationalarchives.gov.uk/doc/open-government-licence/version/3/"
+
+// Downloader implements api.LegacyDownloader and api.PathRouted, and is served with api.Adapt.
 type Downloader struct {
 	contentClient  ZebedeeClient
 	rendererClient RendererClient
+	siteURL        string
+	licence        string
+	contentCheck   string
+	now            func() time.Time
+}
+
+// Option configures optional behaviour of a Downloader
+type Option func(*Downloader)
+
+// WithSiteURL sets the public URL of the website, used to construct links back to the source of a table
+func WithSiteURL(siteURL string) Option {
+	return func(d *Downloader) {
+		d.siteURL = strings.TrimSuffix(siteURL, "/")
+	}
+}
+
+// WithLicence sets the URL of the licence that tables are published under
+func WithLicence(licence string) Option {
+	return func(d *Downloader) {
+		d.licence = licence
+	}
+}
+
+// WithContentCheckName sets the name of the health check of the content server, e.g. when content is read from disk
+func WithContentCheckName(name string) Option {
+	return func(d *Downloader) {
+		d.contentCheck = name
+	}
 }
 
 // NewDownloader returns a new Downloader using rhttp.DefaultClient
-func NewDownloader(contentClient ZebedeeClient, rendererClient RendererClient) Downloader {
-	return Downloader{
+func NewDownloader(contentClient ZebedeeClient, rendererClient RendererClient, opts ...Option) Downloader {
+	downloader := Downloader{
 		contentClient:  contentClient,
 		rendererClient: rendererClient,
+		licence:        DefaultLicence,
+		contentCheck:   DefaultContentCheckName,
+		now:            time.Now,
 	}
+	for _, opt := range opts {
+		opt(&downloader)
+	}
+	return downloader
 }
 
 // Type returns the type of file returned by this downloader, a table.
@@ -38,12 +88,27 @@ func (downloader *Downloader) Type() string {
 }
 
 // QueryParameters returns the format and uri query parameters we require to return a table.
-// 'format' is the format of the file to return - xlsx, csv or html.
+// 'format' is the format of the file to return - html, xlsx, csv, json, md, ods, csvw or csvw-zip.
 // 'uri' is the location of the file that defines the table (a path that resolves to a .json file in the content server).
+// csv, csvw and csvw-zip downloads additionally accept the optional delimiter, bom, line_endings and encoding parameters.
+// xlsx and ods downloads accept the optional metadata parameter, to add a worksheet describing the provenance of the table.
 func (downloader *Downloader) QueryParameters() []string {
 	return []string{formatParam, uriParam}
 }
 
+// HealthChecks returns the checkers of the content server and table-renderer, for those clients that can check the
+// health of their service. Tables rendered in-process have no renderer to check.
+func (downloader *Downloader) HealthChecks() map[string]healthcheck.Checker {
+	checks := make(map[string]healthcheck.Checker)
+	if c, ok := downloader.contentClient.(checker); ok {
+		checks[downloader.contentCheck] = c.Checker
+	}
+	if c, ok := downloader.rendererClient.(checker); ok {
+		checks[RendererCheckName] = c.Checker
+	}
+	return checks
+}
+
 // Download fulfills the Request to download a table.
 // The responseBody must be closed by the caller.
 func (downloader *Downloader) Download(r *http.Request) (responseBody io.ReadCloser, headers map[string]string, responseStatus int, responseErr error) {
@@ -58,10 +123,32 @@ func (downloader *Downloader) Download(r *http.Request) (responseBody io.ReadClo
 		return nil, nil, http.StatusBadRequest, err
 	}
 
+	// the csv query parameters are ignored by formats that contain no csv file
+	f, _ := lookupFormat(format)
+	dialect := defaultCSVDialect
+	if f.csv {
+		if dialect, err = parseCSVDialect(r.URL.Query()); err != nil {
+			return nil, nil, http.StatusBadRequest, err
+		}
+	}
+
+	includeMetadata := false
+	if value := r.URL.Query().Get(metadataParam); value != "" {
+		if includeMetadata, err = strconv.ParseBool(value); err != nil {
+			return nil, nil, http.StatusBadRequest, errors.New("invalid metadata: " + value)
+		}
+		if includeMetadata && !f.metadata {
+			return nil, nil, http.StatusBadRequest, errors.New("a metadata worksheet can not be added to format: " + format)
+		}
+	}
+
 	// call the content server to get the json definition of the table
 	contentResponseBody, err := downloader.contentClient.GetResourceBody(ctx, userAccessToken, collectionID, lang, uri)
 	if err != nil {
 		log.Error(ctx, "error calling content server", err)
+		if timedOut(ctx, err) {
+			return nil, nil, http.StatusGatewayTimeout, fmt.Errorf("timed out waiting for the content server: %w", err)
+		}
 		var e zebedee.ErrInvalidZebedeeResponse
 		if errors.As(err, &e) {
 			if e.ActualCode == http.StatusNotFound {
@@ -74,14 +161,104 @@ func (downloader *Downloader) Download(r *http.Request) (responseBody io.ReadClo
 		return nil, nil, http.StatusInternalServerError, err
 	}
 
+	// malformed definitions are rejected before they reach the renderer, which would fail opaquely or produce a broken file
+	if err := Validate(contentResponseBody); err != nil {
+		log.Warn(ctx, "table definition is invalid", log.Data{"uri": uri, "error": err.Error()})
+		return nil, nil, http.StatusUnprocessableEntity, err
+	}
+
+	// content from a collection is unpublished, so is marked as provisional in the rendered file, whichever renders it
+	req := &renderRequest{ctx: ctx, uri: uri, lang: lang, dialect: dialect, metadata: includeMetadata, preview: collectionID != ""}
+	if req.preview {
+		if contentResponseBody, err = addBannerRow(contentResponseBody, ProvisionalBanner); err != nil {
+			log.Error(ctx, "error adding provisional banner to table definition", err, log.Data{"uri": uri})
+			return nil, nil, http.StatusInternalServerError, err
+		}
+	}
+	if f.isLocal() {
+		return downloader.renderLocally(req, f, contentResponseBody)
+	}
+
 	// post the json definition to the renderer
-	renderResponse, err := downloader.rendererClient.PostBody(ctx, format, contentResponseBody)
+	renderResponse, err := downloader.rendererClient.PostBody(ctx, f.name, contentResponseBody)
 	if err != nil {
 		log.Error(ctx, "error calling renderer server", err)
+		if timedOut(ctx, err) {
+			return nil, nil, http.StatusGatewayTimeout, fmt.Errorf("timed out waiting for the renderer: %w", err)
+		}
 		return nil, nil, http.StatusInternalServerError, err
 	}
 
-	return renderResponse.Body, createHeaders(renderResponse, uri, format), renderResponse.StatusCode, nil
+	responseHeaders := createHeaders(renderResponse.Header.Get("Content-Type"), req, f)
+	if f.name == "xlsx" && renderResponse.StatusCode == http.StatusOK && includeMetadata {
+		xlsx, err := downloader.withMetadataSheet(req, contentResponseBody, renderResponse.Body)
+		if err != nil {
+			log.Error(ctx, "error adding metadata sheet", err, log.Data{"uri": uri})
+			return nil, nil, http.StatusInternalServerError, err
+		}
+		responseHeaders["Content-Length"] = strconv.Itoa(len(xlsx))
+		return io.NopCloser(bytes.NewReader(xlsx)), responseHeaders, renderResponse.StatusCode, nil
+	}
+	if f.name == "csv" && renderResponse.StatusCode == http.StatusOK && !dialect.isDefault() {
+		responseHeaders["Content-Type"] = dialect.contentType()
+		return dialect.rewrite(renderResponse.Body), responseHeaders, renderResponse.StatusCode, nil
+	}
+
+	// the renderer's response is passed on unchanged, so has the same length
+	if renderResponse.ContentLength >= 0 {
+		responseHeaders["Content-Length"] = strconv.FormatInt(renderResponse.ContentLength, 10)
+	}
+	return renderResponse.Body, responseHeaders, renderResponse.StatusCode, nil
+}
+
+// renderLocally renders the json definition of the table in-process, for formats that the renderer service does not support
+func (downloader *Downloader) renderLocally(req *renderRequest, f format, definition []byte) (io.ReadCloser, map[string]string, int, error) {
+	if err := req.parseDefinition(definition); err != nil {
+		log.Error(req.ctx, "error parsing table definition", err, log.Data{"uri": req.uri})
+		return nil, nil, http.StatusInternalServerError, err
+	}
+
+	var buf bytes.Buffer
+	if err := f.render(downloader, req, &buf); err != nil {
+		log.Error(req.ctx, "error rendering table", err, log.Data{"uri": req.uri, "format": f.name})
+		return nil, nil, http.StatusInternalServerError, err
+	}
+
+	headers := createHeaders("", req, f)
+	headers["Content-Length"] = strconv.Itoa(buf.Len())
+	return io.NopCloser(&buf), headers, http.StatusOK, nil
+}
+
+// withMetadataSheet reads the xlsx file produced by the renderer and returns it with a metadata worksheet added.
+// The renderer response body is closed.
+func (downloader *Downloader) withMetadataSheet(req *renderRequest, definition []byte, body io.ReadCloser) ([]byte, error) {
+	defer body.Close()
+
+	if err := req.parseDefinition(definition); err != nil {
+		return nil, err
+	}
+
+	xlsx, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return addMetadataSheet(xlsx, downloader.metadataRows(req))
+}
+
+// sourceURL returns the public URL of the page that the table at uri belongs to. Tables are stored beneath the
+// uri of their page, e.g. /economy/bulletins/inflation/june2017/2c5b6d8f.json is on /economy/bulletins/inflation/june2017.
+func (downloader *Downloader) sourceURL(uri string) string {
+	page := path.Dir(path.Clean("/" + uri))
+	if page == "/" {
+		return downloader.siteURL + "/"
+	}
+	return downloader.siteURL + page
+}
+
+// timedOut returns true if the error was caused by the deadline of the request passing
+func timedOut(ctx context.Context, err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded)
 }
 
 // createContentRequest creates the request to send to the content server, extracting headers and cookies form the source request as appropriate
@@ -98,23 +275,33 @@ func getHeaderValues(ctx context.Context, r *http.Request) (locale, collectionID
 	return locale, collectionID, accessToken
 }
 
-// getContentType extracts the Content-Type from the response and puts it in a map
-func getContentType(response *http.Response) map[string]string {
-	return map[string]string{"Content-Type": response.Header.Get("Content-Type")}
+// createHeaders sets the content type, defaulting to that registered for the format, and constructs a filename
+// from the last path element of the uri and the extension of the format. Previews of unpublished content are marked as such.
+func createHeaders(contentType string, req *renderRequest, f format) map[string]string {
+	if contentType == "" {
+		contentType = f.contentType
+	}
+	headers := map[string]string{"Content-Type": contentType}
+	filename := baseFilename(req.uri) + "." + f.extension
+	headers["Content-Disposition"] = "attachment; filename=\"" + filename + "\""
+	if req.preview {
+		setPreviewHeaders(headers)
+	}
+	return headers
 }
 
-// createHeaders extracts the content type form the response and constructs a filename from the last path element of the uri and the format
-func createHeaders(response *http.Response, uri, format string) map[string]string {
-	headers := getContentType(response)
+// baseFilename returns the last path element of the uri, without the .json extension
+func baseFilename(uri string) string {
 	paths := strings.Split(uri, "/")
-	filename := strings.TrimSuffix(paths[len(paths)-1], ".json") + "." + format
-	headers["Content-Disposition"] = "attachment; filename=\"" + filename + "\""
-	return headers
+	return strings.TrimSuffix(paths[len(paths)-1], ".json")
 }
 
 func validateURL(format, uri string) (err error) {
 	if format == "" || uri == "" {
 		return errors.New("bad request")
 	}
+	if _, ok := lookupFormat(format); !ok {
+		return errors.New("unsupported format: " + format)
+	}
 	return nil
 }
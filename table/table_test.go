@@ -1,16 +1,24 @@
 package table_test
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"encoding/json"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"net/http"
+	"net/url"
 
 	"errors"
 	"io"
 
 	"github.com/ONSdigital/dp-api-clients-go/v2/zebedee"
+	tableRenderer "github.com/ONSdigital/dp-file-downloader/clients/table-renderer"
+	zebedeeFS "github.com/ONSdigital/dp-file-downloader/clients/zebedee-fs"
 	"github.com/ONSdigital/dp-file-downloader/table"
 	"github.com/ONSdigital/dp-file-downloader/table/testdata"
 	. "github.com/smartystreets/goconvey/convey"
@@ -24,7 +32,7 @@ var (
 	uriParam              = "&uri="
 	expectedContentType   = "text/html"
 	expectedContent       = "renderServerResponse"
-	contentServerResponse = "contentServerResponse"
+	contentServerResponse = `{"filename": "bar", "data": [["a", "b"], ["1", "2"]]}`
 	baseURL               = "http://localhost/download/table?format="
 )
 
@@ -41,7 +49,7 @@ func createTableRenderClientMock(status int, testBody, contentType string, err e
 	header.Add("Content-Type", contentType)
 	return &testdata.RendererClientMock{
 		PostBodyFunc: func(ctx context.Context, format string, body []byte) (*http.Response, error) {
-			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(testBody)), Header: header}, err
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(testBody)), Header: header, ContentLength: int64(len(testBody))}, err
 		},
 	}
 }
@@ -74,6 +82,7 @@ func TestSuccessfulDownload(t *testing.T) {
 				So(responseStatus, ShouldEqual, http.StatusOK)
 				So(responseHeaders["Content-Type"], ShouldEqual, expectedContentType)
 				So(responseHeaders["Content-Disposition"], ShouldEqual, expectedDisposition)
+				So(responseHeaders["Content-Length"], ShouldEqual, strconv.Itoa(len(expectedContent)))
 				So(readString(responseBody, t), ShouldEqual, expectedContent)
 			})
 		})
@@ -90,7 +99,7 @@ func TestSuccessfulDownloadForSpecificCollection(t *testing.T) {
 		initialRequest.AddCookie(&http.Cookie{Name: "collection", Value: contentCollection})
 		So(err, ShouldBeNil)
 
-		contentClient := createZebedeeClientMock(contentServerResponse, nil)
+		contentClient := createZebedeeClientMock(tableDefinition, nil)
 		renderClient := createTableRenderClientMock(http.StatusOK, expectedContent, expectedContentType, nil)
 
 		testObj := table.NewDownloader(contentClient, renderClient)
@@ -100,20 +109,95 @@ func TestSuccessfulDownloadForSpecificCollection(t *testing.T) {
 
 			Convey("contentClient should be invoked correctly", func() {
 				So(len(contentClient.GetResourceBodyCalls()), ShouldEqual, 1)
+				So(contentClient.GetResourceBodyCalls()[0].CollectionID, ShouldEqual, contentCollection)
 			})
 
-			Convey("renderClient should be invoked correctly", func() {
+			Convey("renderClient should be invoked with a definition containing a provisional banner row", func() {
 				So(len(renderClient.PostBodyCalls()), ShouldEqual, 1)
+
+				var posted table.Definition
+				So(json.Unmarshal(renderClient.PostBodyCalls()[0].Body, &posted), ShouldBeNil)
+				So(posted.Data, ShouldHaveLength, 4)
+				So(posted.Data[0], ShouldResemble, []string{table.ProvisionalBanner, "", ""})
+				So(posted.Data[1], ShouldResemble, []string{"Area", "2019", "2020"})
+				So(posted.RowFormats, ShouldResemble, []table.RowFormat{{Row: 1, Heading: true}})
+				So(posted.CellFormats, ShouldResemble, []table.CellFormat{{Row: 0, Column: 0, Rowspan: 1, Colspan: 3}})
 			})
 
-			Convey("The correct response should be returned", func() {
+			Convey("The correct response should be returned, marked as a preview", func() {
 				So(responseErr, ShouldBeNil)
 				So(responseStatus, ShouldEqual, http.StatusOK)
 				So(responseHeaders["Content-Type"], ShouldEqual, expectedContentType)
 				So(responseHeaders["Content-Disposition"], ShouldEqual, expectedDisposition)
+				So(responseHeaders[table.PreviewHeader], ShouldEqual, "true")
+				So(responseHeaders["Cache-Control"], ShouldEqual, "private, no-store")
 				So(readString(responseBody, t), ShouldEqual, expectedContent)
 			})
 		})
+
+		Convey("When a format rendered in-process is requested", func() {
+			initialRequest.URL.RawQuery = "format=json&uri=" + requestURI
+			responseBody, responseHeaders, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("The rendered file should be marked as provisional", func() {
+				So(responseErr, ShouldBeNil)
+				So(responseStatus, ShouldEqual, http.StatusOK)
+				So(responseHeaders[table.PreviewHeader], ShouldEqual, "true")
+				So(readString(responseBody, t), ShouldStartWith, `{"notice":"`+table.ProvisionalBanner+`"`)
+			})
+		})
+
+		Convey("When a csvw bundle is requested", func() {
+			initialRequest.URL.RawQuery = "format=csvw-zip&uri=" + requestURI
+			responseBody, _, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("The csv file should begin with the banner, which its metadata skips", func() {
+				So(responseErr, ShouldBeNil)
+				So(responseStatus, ShouldEqual, http.StatusOK)
+
+				body := []byte(readString(responseBody, t))
+				archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+				So(err, ShouldBeNil)
+				csvFile, err := archive.Open("bar.csv")
+				So(err, ShouldBeNil)
+				So(readString(csvFile, t), ShouldStartWith, table.ProvisionalBanner+",,\nArea,2019,2020\n")
+				metadataFile, err := archive.Open("bar.csv-metadata.json")
+				So(err, ShouldBeNil)
+				So(readString(metadataFile, t), ShouldContainSubstring, `"skipRows": 1`)
+			})
+		})
+	})
+
+	Convey("Given a TableDownloader rendering tables in-process and a request for a table in a collection", t, func() {
+		initialRequest, err := http.NewRequest("GET", baseURL+"html"+uriParam+requestURI, http.NoBody)
+		So(err, ShouldBeNil)
+		initialRequest.AddCookie(&http.Cookie{Name: "collection", Value: "myCollection"})
+		testObj := table.NewDownloader(createZebedeeClientMock(tableDefinition, nil), table.LocalRenderer{})
+
+		Convey("When an html download is requested", func() {
+			responseBody, _, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("The banner should be displayed as the notice of the table, above its caption", func() {
+				So(responseErr, ShouldBeNil)
+				So(responseStatus, ShouldEqual, http.StatusOK)
+				body := readString(responseBody, t)
+				So(body, ShouldStartWith, `<div class="table">
+<p class="notice"><strong>`+table.ProvisionalBanner+`</strong></p>`)
+				So(strings.Count(body, table.ProvisionalBanner), ShouldEqual, 1)
+				So(body, ShouldContainSubstring, `<thead>
+<tr><th scope="col">Area</th>`)
+			})
+		})
+
+		Convey("When a csv download is requested", func() {
+			initialRequest.URL.RawQuery = "format=csv&uri=" + requestURI
+			responseBody, _, _, responseErr := testObj.Download(initialRequest)
+
+			Convey("The banner should be the first record, as the renderer service writes it", func() {
+				So(responseErr, ShouldBeNil)
+				So(readString(responseBody, t), ShouldStartWith, table.ProvisionalBanner+",,\nArea,2019,2020\n")
+			})
+		})
 	})
 }
 
@@ -175,7 +259,7 @@ func TestRenderServerError(t *testing.T) {
 
 		expectedErr := errors.New("The render server is down")
 
-		contentClient := createZebedeeClientMock("contentServerResponse", nil)
+		contentClient := createZebedeeClientMock(contentServerResponse, nil)
 		renderClient := createTableRenderClientMock(http.StatusOK, "", "", expectedErr)
 
 		testObj := table.NewDownloader(contentClient, renderClient)
@@ -191,6 +275,63 @@ func TestRenderServerError(t *testing.T) {
 	})
 }
 
+func TestTimeout(t *testing.T) {
+	t.Parallel()
+	Convey("Given the content server is slower than the deadline of the request", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		initialRequest, err := http.NewRequestWithContext(ctx, "GET", "http://localhost/download/table?format=html&uri=/foo/bar", http.NoBody)
+		So(err, ShouldBeNil)
+
+		contentClient := &testdata.ZebedeeClientMock{
+			GetResourceBodyFunc: func(ctx context.Context, userAccessToken string, collectionID string, lang string, uri string) ([]byte, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		}
+		renderClient := createTableRenderClientMock(http.StatusOK, "", "", nil)
+
+		testObj := table.NewDownloader(contentClient, renderClient)
+
+		Convey("When Download is invoked ", func() {
+			_, _, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("A 504 response should be returned without calling the renderer", func() {
+				So(responseErr, ShouldNotBeNil)
+				So(responseErr.Error(), ShouldStartWith, "timed out waiting for the content server")
+				So(responseStatus, ShouldEqual, http.StatusGatewayTimeout)
+				So(len(renderClient.PostBodyCalls()), ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("Given the renderer is slower than the deadline of the request", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		initialRequest, err := http.NewRequestWithContext(ctx, "GET", "http://localhost/download/table?format=html&uri=/foo/bar", http.NoBody)
+		So(err, ShouldBeNil)
+
+		contentClient := createZebedeeClientMock(contentServerResponse, nil)
+		renderClient := &testdata.RendererClientMock{
+			PostBodyFunc: func(ctx context.Context, format string, body []byte) (*http.Response, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		}
+
+		testObj := table.NewDownloader(contentClient, renderClient)
+
+		Convey("When Download is invoked ", func() {
+			_, _, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("A 504 response should be returned", func() {
+				So(responseErr.Error(), ShouldStartWith, "timed out waiting for the renderer")
+				So(responseStatus, ShouldEqual, http.StatusGatewayTimeout)
+			})
+		})
+	})
+}
+
 func TestBadlyFormedRequest(t *testing.T) {
 	t.Parallel()
 	Convey("Given a TableDownloader and a badly formed request", t, func() {
@@ -218,9 +359,622 @@ func TestBadlyFormedRequest(t *testing.T) {
 	})
 }
 
+var tableDefinition = `{
+	"filename": "bar",
+	"title": "Population | by area",
+	"source": "Office for National Statistics",
+	"footnotes": ["Provisional figures"],
+	"data": [["Area", "2019", "2020"], ["England", "1,000", "1,100"], ["Wales", "300", "310"]],
+	"row_formats": [{"row": 0, "heading": true}],
+	"column_formats": [{"col": 1, "align": "right"}]
+}`
+
+func TestLocalFormatDownload(t *testing.T) {
+	t.Parallel()
+	Convey("Given a TableDownloader and a content server returning a table definition", t, func() {
+		contentClient := createZebedeeClientMock(tableDefinition, nil)
+		renderClient := createTableRenderClientMock(http.StatusOK, "", "", nil)
+
+		testObj := table.NewDownloader(contentClient, renderClient)
+
+		Convey("When a json download is requested", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"json"+uriParam+requestURI, http.NoBody)
+			So(err, ShouldBeNil)
+			responseBody, responseHeaders, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("The table should be rendered in-process as a header and rows", func() {
+				So(responseErr, ShouldBeNil)
+				So(len(renderClient.PostBodyCalls()), ShouldEqual, 0)
+				So(responseStatus, ShouldEqual, http.StatusOK)
+				So(responseHeaders["Content-Type"], ShouldEqual, "application/json")
+				So(responseHeaders["Content-Disposition"], ShouldEqual, "attachment; filename=\"bar.json\"")
+				So(readString(responseBody, t), ShouldContainSubstring,
+					`"header":["Area","2019","2020"],"rows":[["England","1,000","1,100"],["Wales","300","310"]]`)
+			})
+		})
+
+		Convey("When a markdown download is requested", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"md"+uriParam+requestURI, http.NoBody)
+			So(err, ShouldBeNil)
+			responseBody, responseHeaders, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("The table should be rendered as a markdown table", func() {
+				So(responseErr, ShouldBeNil)
+				So(responseStatus, ShouldEqual, http.StatusOK)
+				So(responseHeaders["Content-Type"], ShouldEqual, "text/markdown; charset=utf-8")
+				So(responseHeaders["Content-Disposition"], ShouldEqual, "attachment; filename=\"bar.md\"")
+				body := readString(responseBody, t)
+				So(responseHeaders["Content-Length"], ShouldEqual, strconv.Itoa(len(body)))
+				So(body, ShouldStartWith, "## Population \\| by area\n")
+				So(body, ShouldContainSubstring, "| Area | 2019 | 2020 |\n| --- | ---: | --- |\n| England | 1,000 | 1,100 |\n")
+				So(body, ShouldContainSubstring, "1. Provisional figures\n")
+			})
+		})
+
+		Convey("When an ods download is requested", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"ods"+uriParam+requestURI, http.NoBody)
+			So(err, ShouldBeNil)
+			responseBody, responseHeaders, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("The table should be rendered as an OpenDocument spreadsheet", func() {
+				So(responseErr, ShouldBeNil)
+				So(responseStatus, ShouldEqual, http.StatusOK)
+				So(responseHeaders["Content-Type"], ShouldEqual, "application/vnd.oasis.opendocument.spreadsheet")
+				So(responseHeaders["Content-Disposition"], ShouldEqual, "attachment; filename=\"bar.ods\"")
+
+				body := []byte(readString(responseBody, t))
+				archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+				So(err, ShouldBeNil)
+				So(archive.File[0].Name, ShouldEqual, "mimetype")
+				content, err := archive.Open("content.xml")
+				So(err, ShouldBeNil)
+				So(readString(content, t), ShouldContainSubstring, `office:value-type="float" office:value="1000"><text:p>1,000</text:p>`)
+			})
+		})
+	})
+}
+
+func TestCSVWDownload(t *testing.T) {
+	t.Parallel()
+	Convey("Given a TableDownloader with a site url and a content server returning a table definition", t, func() {
+		contentClient := createZebedeeClientMock(tableDefinition, nil)
+		renderClient := createTableRenderClientMock(http.StatusOK, "", "", nil)
+
+		testObj := table.NewDownloader(contentClient, renderClient, table.WithSiteURL("https://www.ons.gov.uk/"))
+
+		Convey("When csvw metadata is requested on its own", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"csvw"+uriParam+requestURI, http.NoBody)
+			So(err, ShouldBeNil)
+			responseBody, responseHeaders, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("A CSVW metadata document describing the csv file of the bundle should be returned", func() {
+				So(responseErr, ShouldBeNil)
+				So(responseStatus, ShouldEqual, http.StatusOK)
+				So(responseHeaders["Content-Type"], ShouldEqual, "application/csvm+json")
+				So(responseHeaders["Content-Disposition"], ShouldEqual, "attachment; filename=\"bar.csv-metadata.json\"")
+
+				var metadata map[string]interface{}
+				So(json.Unmarshal([]byte(readString(responseBody, t)), &metadata), ShouldBeNil)
+				So(metadata["url"], ShouldEqual, "bar.csv")
+				So(metadata["dc:title"], ShouldEqual, "Population | by area")
+				So(metadata["dc:source"], ShouldResemble, map[string]interface{}{"@id": "https://www.ons.gov.uk/foo"})
+				columns := metadata["tableSchema"].(map[string]interface{})["columns"].([]interface{})
+				So(columns, ShouldHaveLength, 3)
+			})
+		})
+
+		Convey("When a csvw bundle is requested", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"csvw-zip"+uriParam+requestURI+"&delimiter=semicolon", http.NoBody)
+			So(err, ShouldBeNil)
+			responseBody, responseHeaders, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("A zip containing the csv file and its metadata should be returned", func() {
+				So(responseErr, ShouldBeNil)
+				So(responseStatus, ShouldEqual, http.StatusOK)
+				So(responseHeaders["Content-Type"], ShouldEqual, "application/zip")
+				So(responseHeaders["Content-Disposition"], ShouldEqual, "attachment; filename=\"bar.zip\"")
+
+				body := []byte(readString(responseBody, t))
+				archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+				So(err, ShouldBeNil)
+				So(archive.File, ShouldHaveLength, 2)
+
+				csvFile, err := archive.Open("bar.csv")
+				So(err, ShouldBeNil)
+				So(readString(csvFile, t), ShouldEqual, "Area;2019;2020\nEngland;1,000;1,100\nWales;300;310\n")
+
+				metadataFile, err := archive.Open("bar.csv-metadata.json")
+				So(err, ShouldBeNil)
+				var metadata map[string]interface{}
+				So(json.Unmarshal([]byte(readString(metadataFile, t)), &metadata), ShouldBeNil)
+				So(metadata["url"], ShouldEqual, "bar.csv")
+				So(metadata["dialect"].(map[string]interface{})["delimiter"], ShouldEqual, ";")
+				So(metadata["dc:title"], ShouldEqual, "Population | by area")
+				So(metadata["dc:source"], ShouldResemble, map[string]interface{}{"@id": "https://www.ons.gov.uk/foo"})
+				So(metadata["dc:license"], ShouldResemble, map[string]interface{}{"@id": table.DefaultLicence})
+
+				columns := metadata["tableSchema"].(map[string]interface{})["columns"].([]interface{})
+				So(columns, ShouldHaveLength, 3)
+				So(columns[0], ShouldResemble, map[string]interface{}{"name": "area", "titles": "Area", "datatype": "string"})
+				So(columns[1].(map[string]interface{})["datatype"], ShouldResemble,
+					map[string]interface{}{"base": "integer", "format": map[string]interface{}{"groupChar": ","}})
+			})
+		})
+	})
+}
+
+func TestXLSXMetadataDownload(t *testing.T) {
+	t.Parallel()
+	Convey("Given a TableDownloader and a renderer returning an xlsx workbook", t, func() {
+		workbook := createWorkbook(t)
+
+		contentClient := createZebedeeClientMock(tableDefinition, nil)
+		renderClient := createTableRenderClientMock(http.StatusOK, string(workbook), "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", nil)
+
+		testObj := table.NewDownloader(contentClient, renderClient, table.WithSiteURL("https://www.ons.gov.uk"))
+
+		Convey("When an xlsx download is requested without metadata", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"xlsx"+uriParam+requestURI, http.NoBody)
+			So(err, ShouldBeNil)
+			responseBody, _, _, responseErr := testObj.Download(initialRequest)
+
+			Convey("The workbook from the renderer should be returned unchanged", func() {
+				So(responseErr, ShouldBeNil)
+				So(readString(responseBody, t), ShouldEqual, string(workbook))
+			})
+		})
+
+		Convey("When an xlsx download is requested with metadata", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"xlsx"+uriParam+requestURI+"&metadata=true", http.NoBody)
+			So(err, ShouldBeNil)
+			responseBody, responseHeaders, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("A metadata worksheet should be added to the workbook", func() {
+				So(responseErr, ShouldBeNil)
+				So(responseStatus, ShouldEqual, http.StatusOK)
+				So(responseHeaders["Content-Disposition"], ShouldEqual, "attachment; filename=\"bar.xlsx\"")
+
+				body := []byte(readString(responseBody, t))
+				archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+				So(err, ShouldBeNil)
+
+				wb, err := archive.Open("xl/workbook.xml")
+				So(err, ShouldBeNil)
+				So(readString(wb, t), ShouldContainSubstring, `<sheet name="Metadata" sheetId="2" r:id="rId3"/></sheets>`)
+
+				rels, err := archive.Open("xl/_rels/workbook.xml.rels")
+				So(err, ShouldBeNil)
+				So(readString(rels, t), ShouldContainSubstring, `Id="rId3"`)
+
+				contentTypes, err := archive.Open("[Content_Types].xml")
+				So(err, ShouldBeNil)
+				So(readString(contentTypes, t), ShouldContainSubstring, `PartName="/xl/worksheets/metadata2.xml"`)
+
+				sheet, err := archive.Open("xl/worksheets/metadata2.xml")
+				So(err, ShouldBeNil)
+				metadata := readString(sheet, t)
+				So(metadata, ShouldContainSubstring, "Population | by area")
+				So(metadata, ShouldContainSubstring, "https://www.ons.gov.uk/foo<")
+				So(metadata, ShouldContainSubstring, table.DefaultLicence)
+				So(metadata, ShouldContainSubstring, "Provisional figures")
+				So(metadata, ShouldContainSubstring, "Generated at")
+			})
+		})
+
+		Convey("When an ods download is requested with metadata", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"ods"+uriParam+requestURI+"&metadata=true", http.NoBody)
+			So(err, ShouldBeNil)
+			responseBody, _, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("A metadata sheet should follow the table in the spreadsheet rendered in-process", func() {
+				So(responseErr, ShouldBeNil)
+				So(responseStatus, ShouldEqual, http.StatusOK)
+
+				body := []byte(readString(responseBody, t))
+				archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+				So(err, ShouldBeNil)
+				content, err := archive.Open("content.xml")
+				So(err, ShouldBeNil)
+				xml := readString(content, t)
+				So(xml, ShouldContainSubstring, `<table:table table:name="Metadata">`)
+				So(xml, ShouldContainSubstring, "<text:p>https://www.ons.gov.uk/foo</text:p>")
+				So(xml, ShouldContainSubstring, "<text:p>Generated at</text:p>")
+			})
+		})
+
+		Convey("When a download of a format without worksheets is requested with metadata", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"csv"+uriParam+requestURI+"&metadata=true", http.NoBody)
+			So(err, ShouldBeNil)
+			_, _, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("A 400 response should be returned", func() {
+				So(responseErr, ShouldNotBeNil)
+				So(responseStatus, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+	})
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	t.Parallel()
+	Convey("Given a TableDownloader and a request for a format that is not registered", t, func() {
+		initialRequest, err := http.NewRequest("GET", baseURL+"pdf"+uriParam+requestURI, http.NoBody)
+		So(err, ShouldBeNil)
+
+		contentClient := createZebedeeClientMock(tableDefinition, nil)
+		renderClient := createTableRenderClientMock(http.StatusOK, "", "", nil)
+
+		testObj := table.NewDownloader(contentClient, renderClient)
+
+		Convey("When Download is invoked ", func() {
+			_, _, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("A 400 response should be returned without calling the content server", func() {
+				So(responseErr, ShouldNotBeNil)
+				So(responseStatus, ShouldEqual, http.StatusBadRequest)
+				So(len(contentClient.GetResourceBodyCalls()), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestCSVDialectDownload(t *testing.T) {
+	t.Parallel()
+	Convey("Given a TableDownloader and a renderer returning a csv file", t, func() {
+		renderedCSV := "Area,Value\n\"Gwynedd, Wales\",café\n"
+
+		contentClient := createZebedeeClientMock(tableDefinition, nil)
+		renderClient := createTableRenderClientMock(http.StatusOK, renderedCSV, "text/csv", nil)
+
+		testObj := table.NewDownloader(contentClient, renderClient)
+
+		Convey("When a csv download is requested without dialect options", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"csv"+uriParam+requestURI, http.NoBody)
+			So(err, ShouldBeNil)
+			responseBody, responseHeaders, _, responseErr := testObj.Download(initialRequest)
+
+			Convey("The csv from the renderer should be returned unchanged", func() {
+				So(responseErr, ShouldBeNil)
+				So(responseHeaders["Content-Type"], ShouldEqual, "text/csv")
+				So(readString(responseBody, t), ShouldEqual, renderedCSV)
+			})
+		})
+
+		Convey("When a csv download is requested with a semicolon delimiter, bom and crlf line endings", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"csv"+uriParam+requestURI+"&delimiter=semicolon&bom=true&line_endings=crlf", http.NoBody)
+			So(err, ShouldBeNil)
+			responseBody, responseHeaders, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("The csv should be rewritten in the requested dialect", func() {
+				So(responseErr, ShouldBeNil)
+				So(responseStatus, ShouldEqual, http.StatusOK)
+				So(responseHeaders["Content-Type"], ShouldEqual, "text/csv; charset=utf-8")
+				So(readString(responseBody, t), ShouldEqual, "\xEF\xBB\xBFArea;Value\r\nGwynedd, Wales;café\r\n")
+			})
+		})
+
+		Convey("When a csv download is requested in windows-1252 with a tab delimiter", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"csv"+uriParam+requestURI+"&delimiter=tab&encoding=windows-1252", http.NoBody)
+			So(err, ShouldBeNil)
+			responseBody, responseHeaders, _, responseErr := testObj.Download(initialRequest)
+
+			Convey("The csv should be transcoded", func() {
+				So(responseErr, ShouldBeNil)
+				So(responseHeaders["Content-Type"], ShouldEqual, "text/csv; charset=windows-1252")
+				So(readString(responseBody, t), ShouldEqual, "Area\tValue\nGwynedd, Wales\tcaf\xE9\n")
+			})
+		})
+
+		Convey("When a csv download is requested with an unsupported delimiter", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"csv"+uriParam+requestURI+"&delimiter=pipe", http.NoBody)
+			So(err, ShouldBeNil)
+			_, _, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("A 400 response should be returned", func() {
+				So(responseErr, ShouldNotBeNil)
+				So(responseStatus, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+
+		Convey("When a download of a format without a csv file is requested with a csv parameter", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"xlsx"+uriParam+requestURI+"&delimiter=pipe", http.NoBody)
+			So(err, ShouldBeNil)
+			responseBody, _, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("The parameter should be ignored", func() {
+				So(responseErr, ShouldBeNil)
+				So(responseStatus, ShouldEqual, http.StatusOK)
+				So(readString(responseBody, t), ShouldEqual, renderedCSV)
+			})
+		})
+
+		Convey("When a windows-1252 csv download is requested with a bom", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"csv"+uriParam+requestURI+"&bom=true&encoding=windows-1252", http.NoBody)
+			So(err, ShouldBeNil)
+			_, _, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("A 400 response should be returned", func() {
+				So(responseErr, ShouldNotBeNil)
+				So(responseStatus, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+	})
+}
+
+// createWorkbook returns a minimal xlsx workbook containing a single sheet
+func TestLocalRenderer(t *testing.T) {
+	t.Parallel()
+	Convey("Given a TableDownloader rendering tables in-process and a content server returning a table definition", t, func() {
+		contentClient := createZebedeeClientMock(tableDefinition, nil)
+		testObj := table.NewDownloader(contentClient, table.LocalRenderer{})
+
+		Convey("When an html download is requested", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"html"+uriParam+requestURI, http.NoBody)
+			So(err, ShouldBeNil)
+			responseBody, responseHeaders, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("The table should be rendered as an html table", func() {
+				So(responseErr, ShouldBeNil)
+				So(responseStatus, ShouldEqual, http.StatusOK)
+				So(responseHeaders["Content-Type"], ShouldEqual, "text/html; charset=utf-8")
+				So(responseHeaders["Content-Disposition"], ShouldEqual, expectedDisposition)
+				body := readString(responseBody, t)
+				So(body, ShouldContainSubstring, "<caption>Population | by area</caption>")
+				So(body, ShouldContainSubstring, `<thead>
+<tr><th scope="col">Area</th><th scope="col" class="align-right">2019</th><th scope="col">2020</th></tr>
+</thead>`)
+				So(body, ShouldContainSubstring, `<tr><td>England</td><td class="align-right">1,000</td><td>1,100</td></tr>`)
+				So(body, ShouldContainSubstring, "<li>Provisional figures</li>")
+			})
+		})
+
+		Convey("When a csv download is requested", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"csv"+uriParam+requestURI+"&delimiter=semicolon", http.NoBody)
+			So(err, ShouldBeNil)
+			responseBody, _, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("Every row of the table should be rendered in the requested dialect", func() {
+				So(responseErr, ShouldBeNil)
+				So(responseStatus, ShouldEqual, http.StatusOK)
+				So(readString(responseBody, t), ShouldEqual, "Area;2019;2020\nEngland;1,000;1,100\nWales;300;310\n")
+			})
+		})
+
+		Convey("When an xlsx download is requested", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+"xlsx"+uriParam+requestURI, http.NoBody)
+			So(err, ShouldBeNil)
+			_, _, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("An error should be returned, as xlsx files can only be rendered by the renderer service", func() {
+				So(responseErr, ShouldNotBeNil)
+				So(responseStatus, ShouldEqual, http.StatusInternalServerError)
+			})
+		})
+	})
+}
+
+func TestInvalidDefinition(t *testing.T) {
+	t.Parallel()
+	Convey("Given a TableDownloader and a content server returning a malformed table definition", t, func() {
+		contentClient := createZebedeeClientMock(`{"filename": "bar", "data": [["a", "b"], ["c"]]}`, nil)
+		renderClient := createTableRenderClientMock(http.StatusOK, expectedContent, expectedContentType, nil)
+
+		testObj := table.NewDownloader(contentClient, renderClient)
+
+		Convey("When Download is invoked ", func() {
+			initialRequest, err := http.NewRequest("GET", baseURL+requestFormat+uriParam+requestURI, http.NoBody)
+			So(err, ShouldBeNil)
+			_, _, responseStatus, responseErr := testObj.Download(initialRequest)
+
+			Convey("A 422 response listing the violations should be returned without calling the renderer", func() {
+				So(responseStatus, ShouldEqual, http.StatusUnprocessableEntity)
+				var validationErr *table.ValidationError
+				So(errors.As(responseErr, &validationErr), ShouldBeTrue)
+				So(validationErr.Violations, ShouldResemble, []table.Violation{
+					{Field: "data[1]", Message: "has 1 cells, but the table is 2 columns wide"},
+				})
+				So(responseErr.Error(), ShouldEqual,
+					"table definition does not conform to schema version 1:\n- data[1]: has 1 cells, but the table is 2 columns wide")
+				So(len(renderClient.PostBodyCalls()), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	violations := func(definition string) []string {
+		err := table.Validate([]byte(definition))
+		if err == nil {
+			return nil
+		}
+		var validationErr *table.ValidationError
+		So(errors.As(err, &validationErr), ShouldBeTrue)
+		var descriptions []string
+		for _, v := range validationErr.Violations {
+			descriptions = append(descriptions, v.String())
+		}
+		return descriptions
+	}
+
+	Convey("Given a valid table definition", t, func() {
+		Convey("No violations should be reported", func() {
+			So(table.Validate([]byte(tableDefinition)), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a table definition that is not a json object", t, func() {
+		Convey("The definition should be reported as invalid", func() {
+			So(violations(`{"data": [`), ShouldResemble, []string{"definition must be a json object"})
+			So(violations(`[]`), ShouldResemble, []string{"definition must be a json object"})
+		})
+	})
+
+	Convey("Given a table definition declaring an unknown schema version", t, func() {
+		Convey("The version should be reported as unsupported", func() {
+			So(violations(`{"schema_version": "9", "filename": "bar", "data": [["a"]]}`), ShouldResemble,
+				[]string{`schema_version: unsupported version "9", must be one of 1`})
+		})
+	})
+
+	Convey("Given a table definition with missing and mistyped fields", t, func() {
+		Convey("Each should be reported", func() {
+			So(violations(`{"title": 3, "notes": "a note", "data": [["a", 1], "b"]}`), ShouldResemble, []string{
+				"filename: is required",
+				"title: must be a string",
+				"notes: must be an array of strings",
+				"data[0][1]: must be a string",
+				"data[1]: must be an array of cells",
+			})
+			So(violations(`{"filename": " ", "data": []}`), ShouldResemble, []string{
+				"filename: must not be empty",
+				"data: must contain at least one row",
+			})
+		})
+	})
+
+	Convey("Given a table definition with inconsistent rows and formats", t, func() {
+		definition := `{
+			"filename": "bar",
+			"data": [["a", "b"], ["c"]],
+			"row_formats": [{"row": 2, "heading": true}],
+			"column_formats": [{"col": 1, "align": "middle"}],
+			"cell_formats": [{"row": 0, "col": 0, "rowspan": 2}, {"row": 1, "col": 0, "colspan": 2}, {"row": 1, "col": 1, "rowspan": 2}]
+		}`
+
+		Convey("Each violation should be reported", func() {
+			So(violations(definition), ShouldResemble, []string{
+				"data[1]: has 1 cells, but the table is 2 columns wide",
+				"row_formats[0].row: refers to row 2, outside the table",
+				`column_formats[0].align: unsupported alignment "middle"`,
+				"cell_formats[1]: overlaps the merged cell of cell_formats[0]",
+				"cell_formats[2]: merged cell (1, 1) extends beyond the table",
+			})
+		})
+	})
+
+	Convey("Given a table definition styling cells that are merged or already formatted", t, func() {
+		definition := `{
+			"filename": "bar",
+			"data": [["a", "b"], ["c", "d"]],
+			"cell_formats": [
+				{"row": 0, "col": 0, "colspan": 2},
+				{"row": 0, "col": 0, "align": "center"},
+				{"row": 0, "col": 1, "align": "right"},
+				{"row": 1, "col": 1, "align": "right"},
+				{"row": 1, "col": 1, "bold": true}
+			]
+		}`
+
+		Convey("No violations should be reported, as only merged cells take up the cells beside them", func() {
+			So(table.Validate([]byte(definition)), ShouldBeNil)
+		})
+	})
+}
+
+func createWorkbook(t *testing.T) []byte {
+	parts := map[string]string{
+		"[Content_Types].xml": `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`,
+		"xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="bar" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId1" Target="worksheets/sheet1.xml"/>` +
+			`<Relationship Id="rId2" Target="styles.xml"/></Relationships>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData/></worksheet>`,
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readString reads the body to EOF before asserting on anything, as a rewritten body is written by another goroutine
+// until then
 func readString(reader io.Reader, _ *testing.T) string {
-	So(reader, ShouldNotBeNil)
+	So(reader != nil, ShouldBeTrue)
 	bytes, e := io.ReadAll(reader)
 	So(e, ShouldBeNil)
 	return string(bytes)
 }
+
+func TestHealthChecks(t *testing.T) {
+	t.Parallel()
+	Convey("Given a Downloader whose clients can check the health of their services", t, func() {
+		downloader := table.NewDownloader(zebedeeFS.New(t.TempDir()), tableRenderer.New("http://localhost:23300"), table.WithContentCheckName("local content"))
+
+		Convey("Its health checks should include both services", func() {
+			checks := downloader.HealthChecks()
+			So(checks, ShouldHaveLength, 2)
+			So(checks, ShouldContainKey, "local content")
+			So(checks, ShouldContainKey, table.RendererCheckName)
+		})
+	})
+
+	Convey("Given a Downloader that renders tables in-process", t, func() {
+		downloader := table.NewDownloader(createZebedeeClientMock("", nil), table.LocalRenderer{})
+
+		Convey("It should have no health checks", func() {
+			So(downloader.HealthChecks(), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestRoute(t *testing.T) {
+	t.Parallel()
+	Convey("Given the path-style routes of a Downloader", t, func() {
+		downloader := table.NewDownloader(createZebedeeClientMock("", nil), table.LocalRenderer{})
+		So(downloader.Paths(), ShouldResemble, []string{"/{path:.+}"})
+
+		Convey("A path with the extension of a format should resolve to the uri of the table in that format", func() {
+			for path, format := range map[string]string{
+				"economy/mytable.csv":               "csv",
+				"economy/mytable.json":              "json",
+				"economy/mytable.csv-metadata.json": "csvw",
+				"economy/mytable.zip":               "csvw-zip",
+			} {
+				params, err := downloader.ResolvePath(map[string]string{"path": path})
+				So(err, ShouldBeNil)
+				So(params.Get("format"), ShouldEqual, format)
+				So(params.Get("uri"), ShouldEqual, "/economy/mytable.json")
+			}
+		})
+
+		Convey("A path without the extension of a format should not resolve", func() {
+			_, err := downloader.ResolvePath(map[string]string{"path": "economy/mytable.pdf"})
+			So(err, ShouldNotBeNil)
+			_, err = downloader.ResolvePath(map[string]string{"path": ".csv"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The canonical path of a download should be its uri with the extension of its format", func() {
+			path, query, ok := downloader.CanonicalPath(url.Values{"format": {"csvw-zip"}, "uri": {"/economy/mytable.json"}, "delimiter": {"tab"}})
+			So(ok, ShouldBeTrue)
+			So(path, ShouldEqual, "economy/mytable.zip")
+			So(query, ShouldResemble, url.Values{"delimiter": {"tab"}})
+
+			params, err := downloader.ResolvePath(map[string]string{"path": path})
+			So(err, ShouldBeNil)
+			So(params.Get("format"), ShouldEqual, "csvw-zip")
+			So(params.Get("uri"), ShouldEqual, "/economy/mytable.json")
+		})
+
+		Convey("A download of a uri that is not a json file should have no canonical path", func() {
+			_, _, ok := downloader.CanonicalPath(url.Values{"format": {"csv"}, "uri": {"/economy/mytable"}})
+			So(ok, ShouldBeFalse)
+			_, _, ok = downloader.CanonicalPath(url.Values{"format": {"pdf"}, "uri": {"/economy/mytable.json"}})
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
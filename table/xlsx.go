@@ -0,0 +1,182 @@
+package table
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var metadataParam = "metadata"
+
+const metadataSheetName = "Metadata"
+
+var (
+	sheetIDPattern        = regexp.MustCompile(`sheetId="(\d+)"`)
+	relationshipIDPattern = regexp.MustCompile(`Id="rId(\d+)"`)
+)
+
+const (
+	workbookPath      = "xl/workbook.xml"
+	workbookRelsPath  = "xl/_rels/workbook.xml.rels"
+	contentTypesPath  = "[Content_Types].xml"
+	worksheetMimeType = "application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"
+	worksheetRelType  = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet"
+)
+
+// metadataRows returns the label/value pairs describing the provenance of the table, for the metadata worksheet
+func (downloader *Downloader) metadataRows(req *renderRequest) [][2]string {
+	def := req.def
+	var rows [][2]string
+	if def.notice != "" {
+		rows = append(rows, [2]string{"Status", def.notice})
+	}
+	rows = append(rows, [2]string{"Title", def.Title})
+	if def.Subtitle != "" {
+		rows = append(rows, [2]string{"Subtitle", def.Subtitle})
+	}
+	rows = append(rows, [2]string{"Source page", downloader.sourceURL(req.uri)})
+	if def.Source != "" {
+		rows = append(rows, [2]string{"Source", def.Source})
+	}
+	if def.ReleaseDate != "" {
+		rows = append(rows, [2]string{"Release date", def.ReleaseDate})
+	}
+	if downloader.licence != "" {
+		rows = append(rows, [2]string{"Licence", downloader.licence})
+	}
+	for _, note := range def.Notes {
+		rows = append(rows, [2]string{"Note", note})
+	}
+	for i, footnote := range def.Footnotes {
+		rows = append(rows, [2]string{"Footnote " + strconv.Itoa(i+1), footnote})
+	}
+	rows = append(rows, [2]string{"Generated at", downloader.now().UTC().Format(time.RFC3339)})
+	return rows
+}
+
+// addMetadataSheet returns a copy of the xlsx workbook with an additional worksheet containing the given label/value pairs.
+// The workbook may have been produced by any renderer, as only the package structure common to all xlsx files is relied upon.
+func addMetadataSheet(xlsx []byte, rows [][2]string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(xlsx), int64(len(xlsx)))
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make(map[string]string)
+	for _, name := range []string{workbookPath, workbookRelsPath, contentTypesPath} {
+		if parts[name], err = readZipFile(zr, name); err != nil {
+			return nil, err
+		}
+	}
+
+	workbook := parts[workbookPath]
+	if strings.Contains(workbook, `name="`+metadataSheetName+`"`) {
+		return nil, errors.New("workbook already contains a " + metadataSheetName + " sheet")
+	}
+	sheetID := maxSubmatch(sheetIDPattern, workbook) + 1
+	relID := fmt.Sprintf("rId%d", maxSubmatch(relationshipIDPattern, parts[workbookRelsPath])+1)
+	sheetPath := fmt.Sprintf("worksheets/%s%d.xml", strings.ToLower(metadataSheetName), sheetID)
+
+	parts[workbookPath], err = insertBefore(workbook, "</sheets>",
+		fmt.Sprintf(`<sheet name="%s" sheetId="%d" r:id="%s"/>`, metadataSheetName, sheetID, relID))
+	if err != nil {
+		return nil, err
+	}
+	parts[workbookRelsPath], err = insertBefore(parts[workbookRelsPath], "</Relationships>",
+		fmt.Sprintf(`<Relationship Id="%s" Type="%s" Target="%s"/>`, relID, worksheetRelType, sheetPath))
+	if err != nil {
+		return nil, err
+	}
+	parts[contentTypesPath], err = insertBefore(parts[contentTypesPath], "</Types>",
+		fmt.Sprintf(`<Override PartName="/xl/%s" ContentType="%s"/>`, sheetPath, worksheetMimeType))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range zr.File {
+		content, modified := parts[f.Name]
+		if !modified {
+			if err = zw.Copy(f); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err = writeZipFile(zw, f.Name, content); err != nil {
+			return nil, err
+		}
+	}
+	if err = writeZipFile(zw, "xl/"+sheetPath, metadataWorksheet(rows)); err != nil {
+		return nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// metadataWorksheet returns the xml of a worksheet with a label column and a value column, using inline strings
+// so that the shared strings of the workbook don't need to be modified
+func metadataWorksheet(rows [][2]string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`)
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	b.WriteString(`<cols><col min="1" max="1" width="20" customWidth="1"/><col min="2" max="2" width="100" customWidth="1"/></cols>`)
+	b.WriteString("<sheetData>")
+	for i, row := range rows {
+		r := strconv.Itoa(i + 1)
+		b.WriteString(`<row r="` + r + `">`)
+		b.WriteString(`<c r="A` + r + `" t="inlineStr"><is><t>` + xmlEscape(row[0]) + `</t></is></c>`)
+		b.WriteString(`<c r="B` + r + `" t="inlineStr"><is><t xml:space="preserve">` + xmlEscape(row[1]) + `</t></is></c>`)
+		b.WriteString("</row>")
+	}
+	b.WriteString("</sheetData></worksheet>")
+	return b.String()
+}
+
+func readZipFile(zr *zip.Reader, name string) (string, error) {
+	f, err := zr.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("invalid xlsx file: %w", err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	return string(b), err
+}
+
+func writeZipFile(zw *zip.Writer, name, content string) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, content)
+	return err
+}
+
+// insertBefore inserts value before the last occurrence of the closing xml tag
+func insertBefore(document, closingTag, value string) (string, error) {
+	i := strings.LastIndex(document, closingTag)
+	if i < 0 {
+		return "", errors.New("invalid xlsx file: missing " + closingTag)
+	}
+	return document[:i] + value + document[i:], nil
+}
+
+// maxSubmatch returns the largest integer captured by the pattern in the document
+func maxSubmatch(pattern *regexp.Regexp, document string) int {
+	highest := 0
+	for _, match := range pattern.FindAllStringSubmatch(document, -1) {
+		if n, err := strconv.Atoi(match[1]); err == nil && n > highest {
+			highest = n
+		}
+	}
+	return highest
+}
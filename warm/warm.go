@@ -0,0 +1,159 @@
+package warm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-file-downloader/event"
+	"github.com/ONSdigital/dp-file-downloader/ratelimit"
+	"github.com/ONSdigital/log.go/v2/log"
+)
+
+// ErrQueueFull is returned when more downloads are requested to be warmed than can be queued
+var ErrQueueFull = errors.New("warm queue is full")
+
+// Config configures which downloads are warmed, and how quickly
+type Config struct {
+	// Formats are the formats each uri is rendered in
+	Formats []string
+	// Languages are the languages each uri is rendered in
+	Languages []string
+	// Concurrency is the maximum number of downloads rendered at once
+	Concurrency int
+	// Rate is the maximum number of downloads rendered per second, or unlimited if it is not positive
+	Rate float64
+	// QueueSize is the maximum number of downloads waiting to be rendered
+	QueueSize int
+}
+
+// Warmer pre-renders downloads into the download cache, by requesting them from the download handler as a user would
+type Warmer struct {
+	handler http.Handler
+	path    string
+	cfg     Config
+	jobs    chan *http.Request
+}
+
+// New returns a Warmer that renders downloads of the given downloader type by requesting them from the handler, at the
+// path of the downloader type beneath pathPrefix (e.g. /download). It does nothing until it is started.
+func New(handler http.Handler, pathPrefix, downloaderType string, cfg Config) *Warmer {
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = 1
+	}
+	return &Warmer{
+		handler: handler,
+		path:    strings.TrimSuffix(pathPrefix, "/") + "/" + downloaderType,
+		cfg:     cfg,
+		jobs:    make(chan *http.Request, cfg.QueueSize),
+	}
+}
+
+// Start renders queued downloads in the background until the context is done
+func (w *Warmer) Start(ctx context.Context) {
+	var limit <-chan time.Time
+	if w.cfg.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / w.cfg.Rate))
+		limit = ticker.C
+		go func() {
+			<-ctx.Done()
+			ticker.Stop()
+		}()
+	}
+
+	for i := 0; i < w.cfg.Concurrency; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case r := <-w.jobs:
+					if limit != nil {
+						select {
+						case <-ctx.Done():
+							return
+						case <-limit:
+						}
+					}
+					w.render(r.WithContext(ratelimit.Exempt(ctx)))
+				}
+			}
+		}()
+	}
+}
+
+// Warm queues every configured format and language of the uris to be rendered, returning the number of downloads queued.
+// If the queue fills up, the remaining downloads are dropped and ErrQueueFull is returned.
+func (w *Warmer) Warm(ctx context.Context, uris []string) (int, error) {
+	queued := 0
+	for _, uri := range uris {
+		for _, format := range w.cfg.Formats {
+			for _, lang := range w.cfg.Languages {
+				select {
+				case w.jobs <- w.newRequest(uri, format, lang):
+					queued++
+				default:
+					log.Warn(ctx, "warm queue is full, dropping downloads", log.Data{"queued": queued})
+					return queued, ErrQueueFull
+				}
+			}
+		}
+	}
+	return queued, nil
+}
+
+// OnPublish returns an event handler that warms the downloads of published content
+func (w *Warmer) OnPublish() event.Handler {
+	return func(ctx context.Context, e *event.ContentPublished) error {
+		_, err := w.Warm(ctx, e.URIs)
+		return err
+	}
+}
+
+// newRequest returns an anonymous request for the download, so that it is cached as a published download. As the
+// language of a published download is that of its host, the language is given as the subdomain of the host. It is
+// marked as internal when it is rendered, so that it isn't rate limited.
+func (w *Warmer) newRequest(uri, format, lang string) *http.Request {
+	query := url.Values{"format": {format}, "uri": {uri}}
+	return &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: w.path, RawQuery: query.Encode()},
+		Header: make(http.Header),
+		Host:   lang + ".localhost",
+	}
+}
+
+// render requests the download from the handler, discarding the response
+func (w *Warmer) render(r *http.Request) {
+	start := time.Now()
+	rw := &discardResponse{header: make(http.Header), status: http.StatusOK}
+	w.handler.ServeHTTP(rw, r)
+
+	logData := log.Data{"url": r.URL.String(), "status": rw.status, "duration": time.Since(start)}
+	if rw.status != http.StatusOK {
+		log.Warn(r.Context(), "failed to warm download", logData)
+		return
+	}
+	log.Info(r.Context(), "warmed download", logData)
+}
+
+// discardResponse is a http.ResponseWriter that records the status and discards the body
+type discardResponse struct {
+	header http.Header
+	status int
+}
+
+func (d *discardResponse) Header() http.Header {
+	return d.header
+}
+
+func (d *discardResponse) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (d *discardResponse) WriteHeader(status int) {
+	d.status = status
+}
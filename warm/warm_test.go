@@ -0,0 +1,172 @@
+package warm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-file-downloader/event"
+	"github.com/ONSdigital/dp-file-downloader/ratelimit"
+	"github.com/ONSdigital/dp-net/v3/request"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recordingHandler records the downloads requested from it
+type recordingHandler struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	requests []string
+}
+
+func (h *recordingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer h.wg.Done()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests = append(h.requests, r.URL.Path+"?"+r.URL.RawQuery+"|"+request.GetLocaleCode(r))
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestWarm(t *testing.T) {
+	t.Parallel()
+	Convey("Given a started warmer for two formats and languages", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		handler := &recordingHandler{}
+		warmer := New(handler, "/download", "table", Config{
+			Formats:     []string{"csv", "xlsx"},
+			Languages:   []string{"en", "cy"},
+			Concurrency: 2,
+			Rate:        1000,
+			QueueSize:   10,
+		})
+		warmer.Start(ctx)
+
+		Convey("When a publishing event is handled", func() {
+			handler.wg.Add(4)
+			err := warmer.OnPublish()(ctx, &event.ContentPublished{URIs: []string{"/a/table"}})
+			So(err, ShouldBeNil)
+			handler.wg.Wait()
+
+			Convey("Every format and language of the uri should be requested", func() {
+				sort.Strings(handler.requests)
+				So(handler.requests, ShouldResemble, []string{
+					"/download/table?format=csv&uri=%2Fa%2Ftable|cy",
+					"/download/table?format=csv&uri=%2Fa%2Ftable|en",
+					"/download/table?format=xlsx&uri=%2Fa%2Ftable|cy",
+					"/download/table?format=xlsx&uri=%2Fa%2Ftable|en",
+				})
+			})
+		})
+	})
+
+	Convey("Given a warmer that hasn't been started", t, func() {
+		warmer := New(&recordingHandler{}, "/download", "table", Config{
+			Formats:   []string{"csv", "xlsx"},
+			Languages: []string{"en"},
+			QueueSize: 3,
+		})
+
+		Convey("When more downloads are requested than can be queued", func() {
+			queued, err := warmer.Warm(context.Background(), []string{"/a", "/b"})
+
+			Convey("The downloads that fit should be queued and an error returned", func() {
+				So(queued, ShouldEqual, 3)
+				So(err, ShouldEqual, ErrQueueFull)
+			})
+		})
+	})
+
+	Convey("Given a started warmer for downloads routed beneath another path prefix", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		handler := &recordingHandler{}
+		warmer := New(handler, "/files/", "table", Config{Formats: []string{"csv"}, Languages: []string{"en"}, QueueSize: 10})
+		warmer.Start(ctx)
+
+		Convey("When a uri is warmed", func() {
+			handler.wg.Add(1)
+			_, err := warmer.Warm(ctx, []string{"/a/table"})
+			So(err, ShouldBeNil)
+			handler.wg.Wait()
+
+			Convey("Its downloads should be requested beneath the prefix", func() {
+				So(handler.requests, ShouldResemble, []string{"/files/table?format=csv&uri=%2Fa%2Ftable|en"})
+			})
+		})
+	})
+
+	Convey("Given a started warmer limited to 20 downloads per second", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		handler := &recordingHandler{}
+		warmer := New(handler, "/download", "table", Config{
+			Formats:     []string{"csv"},
+			Languages:   []string{"en"},
+			Concurrency: 4,
+			Rate:        20,
+			QueueSize:   10,
+		})
+		warmer.Start(ctx)
+
+		Convey("When several downloads are warmed", func() {
+			handler.wg.Add(4)
+			start := time.Now()
+			_, err := warmer.Warm(ctx, []string{"/a", "/b", "/c", "/d"})
+			So(err, ShouldBeNil)
+			handler.wg.Wait()
+
+			Convey("They should be rendered no faster than the rate", func() {
+				So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 150*time.Millisecond)
+			})
+		})
+	})
+
+	Convey("Given a started warmer requesting downloads from a rate limited handler", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		limiter, err := ratelimit.New(ratelimit.Config{Rate: 1, Burst: 1})
+		So(err, ShouldBeNil)
+		recorder := &recordingHandler{}
+		limited := limiter.Middleware("table")(recorder)
+		var (
+			mu       sync.Mutex
+			wg       sync.WaitGroup
+			statuses []int
+		)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer wg.Done()
+			rw := httptest.NewRecorder()
+			limited.ServeHTTP(rw, r)
+			mu.Lock()
+			defer mu.Unlock()
+			statuses = append(statuses, rw.Code)
+		})
+		warmer := New(handler, "/download", "table", Config{
+			Formats:     []string{"csv"},
+			Languages:   []string{"en"},
+			Concurrency: 1,
+			QueueSize:   10,
+		})
+		warmer.Start(ctx)
+
+		Convey("When more downloads are warmed than the rate limit allows", func() {
+			wg.Add(3)
+			recorder.wg.Add(3)
+			_, err := warmer.Warm(ctx, []string{"/a", "/b", "/c"})
+			So(err, ShouldBeNil)
+			wg.Wait()
+
+			Convey("None of them should be rate limited", func() {
+				So(statuses, ShouldResemble, []int{http.StatusOK, http.StatusOK, http.StatusOK})
+			})
+		})
+	})
+}